@@ -0,0 +1,10 @@
+package toolbelt
+
+import "golang.org/x/crypto/argon2"
+
+// DeriveKey stretches password into a 32 byte key with argon2, suitable for
+// chacha20poly1305. Anything that must read data sealed by another tool in
+// this module derives its key here so the parameters stay in step.
+func DeriveKey(password, salt []byte) []byte {
+	return argon2.Key(password, salt, 3, 64*1024, 4, 32)
+}
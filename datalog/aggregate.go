@@ -0,0 +1,143 @@
+package datalog
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Aggregate functions that can wrap a variable in a find spec, for example
+// "count(?m)". Results are grouped by the other elements of the find spec.
+const (
+	AggregateCount    = "count"
+	AggregateSum      = "sum"
+	AggregateMin      = "min"
+	AggregateMax      = "max"
+	AggregateDistinct = "distinct"
+)
+
+type findSpec struct {
+	aggregate string
+	variable  string
+}
+
+func parseFindSpec(find string) findSpec {
+	open := strings.IndexByte(find, '(')
+	if open <= 0 || !strings.HasSuffix(find, ")") {
+		return findSpec{variable: find}
+	}
+	name := strings.TrimSpace(find[:open])
+	variable := strings.TrimSpace(find[open+1 : len(find)-1])
+	switch name {
+	case AggregateCount, AggregateSum, AggregateMin, AggregateMax, AggregateDistinct:
+		if isVariable(variable) {
+			return findSpec{aggregate: name, variable: variable}
+		}
+	}
+	return findSpec{variable: find}
+}
+
+// project turns states into result rows. Without aggregates there is one row
+// per state; otherwise states are grouped by the plain find elements and each
+// aggregate is computed per group, keeping groups in first-seen order.
+func project(states []State, find []string) [][]Value {
+	specs := make([]findSpec, len(find))
+	aggregated := false
+	for i, f := range find {
+		specs[i] = parseFindSpec(f)
+		aggregated = aggregated || specs[i].aggregate != ""
+	}
+
+	if !aggregated {
+		results := make([][]Value, len(states))
+		for i, state := range states {
+			results[i] = actualizeValues(state, find...)
+		}
+		return results
+	}
+
+	type group struct {
+		row    []Value
+		states []State
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, state := range states {
+		row := make([]Value, len(specs))
+		var key strings.Builder
+		for i, spec := range specs {
+			if spec.aggregate != "" {
+				continue
+			}
+			row[i] = actualizeValues(state, spec.variable)[0]
+			key.WriteString(strconv.Itoa(int(KindOf(row[i]))))
+			key.WriteByte(':')
+			key.WriteString(FormatValue(row[i]))
+			key.WriteByte(0)
+		}
+		g, ok := byKey[key.String()]
+		if !ok {
+			g = &group{row: row}
+			byKey[key.String()] = g
+			groups = append(groups, g)
+		}
+		g.states = append(g.states, state)
+	}
+
+	results := make([][]Value, len(groups))
+	for i, g := range groups {
+		for j, spec := range specs {
+			if spec.aggregate != "" {
+				g.row[j] = aggregate(spec, g.states)
+			}
+		}
+		results[i] = g.row
+	}
+	return results
+}
+
+func aggregate(spec findSpec, states []State) Value {
+	values := make([]Value, 0, len(states))
+	for _, state := range states {
+		if v, ok := state[spec.variable]; ok {
+			values = append(values, normalizeValue(v))
+		}
+	}
+
+	switch spec.aggregate {
+	case AggregateCount:
+		return int64(len(values))
+	case AggregateSum:
+		var (
+			ints    int64
+			floats  float64
+			isFloat bool
+		)
+		for _, v := range values {
+			switch v := v.(type) {
+			case int64:
+				ints += v
+			case float64:
+				floats += v
+				isFloat = true
+			}
+		}
+		if isFloat {
+			return floats + float64(ints)
+		}
+		return ints
+	case AggregateMin, AggregateMax:
+		if len(values) == 0 {
+			return nil
+		}
+		if spec.aggregate == AggregateMin {
+			return slices.MinFunc(values, Compare)
+		}
+		return slices.MaxFunc(values, Compare)
+	case AggregateDistinct:
+		slices.SortFunc(values, Compare)
+		return slices.CompactFunc(values, Equal)
+	default:
+		return nil
+	}
+}
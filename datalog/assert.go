@@ -0,0 +1,163 @@
+package datalog
+
+import (
+	"slices"
+	"time"
+)
+
+// Assert adds a triple to the database and reports whether it was new.
+// Asserting a triple that is already stored is a no-op. Triples derived from
+// rules are updated incrementally. It panics with ErrInvalidValue if the
+// triple can not be stored.
+func (db *DB) Assert(triple Triple) bool {
+	return db.AssertBatch(triple) == 1
+}
+
+// AssertBatch adds all triples under a single lock and reports how many were
+// not already stored. Like Assert it panics with ErrInvalidValue, before
+// storing anything, if a triple can not be stored.
+func (db *DB) AssertBatch(triples ...Triple) int {
+	mustCheckTriples(triples)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.assertBatch(db.now(), triples)
+}
+
+// AssertBatchAt is like AssertBatch but records tx as the transaction time in
+// the history, see AsOf. Changes are applied in order, so tx may not be
+// earlier than the last recorded change, otherwise ErrTxOutOfOrder is
+// returned and nothing is stored. Triples that can not be stored are
+// reported with ErrInvalidValue rather than a panic.
+func (db *DB) AssertBatchAt(tx time.Time, triples ...Triple) (int, error) {
+	for _, triple := range triples {
+		if err := checkTriple(triple); err != nil {
+			return 0, err
+		}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkTx(tx); err != nil {
+		return 0, err
+	}
+	return db.assertBatch(tx, triples), nil
+}
+
+func (db *DB) assertBatch(tx time.Time, triples []Triple) int {
+	added := make([]Triple, 0, len(triples))
+	for _, triple := range triples {
+		if db.has(triple) {
+			continue
+		}
+		triple = normalizeTriple(triple)
+		db.insert(triple)
+		db.record(Datom{Triple: triple, Tx: tx, Added: true})
+		added = append(added, triple)
+	}
+
+	switch {
+	case db.derived == nil || len(added) == 0:
+	case db.hasNegation():
+		db.evaluateRules()
+	default:
+		// A derived triple that is now asserted directly should only be
+		// reported once.
+		db.derived.remove(added...)
+		db.propagate(db.rules, added)
+	}
+
+	return len(added)
+}
+
+// Retract removes a triple from the database and reports whether it was
+// stored.
+func (db *DB) Retract(triple Triple) bool {
+	return db.RetractBatch(triple) == 1
+}
+
+// RetractBatch removes all triples under a single lock and reports how many
+// were stored. Triples derived from rules are recomputed, since a retraction
+// may remove the only support for a derived fact.
+func (db *DB) RetractBatch(triples ...Triple) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.retractBatch(db.now(), triples)
+}
+
+// RetractBatchAt is like RetractBatch but records tx as the transaction time
+// in the history, see AssertBatchAt.
+func (db *DB) RetractBatchAt(tx time.Time, triples ...Triple) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.checkTx(tx); err != nil {
+		return 0, err
+	}
+	return db.retractBatch(tx, triples), nil
+}
+
+func (db *DB) retractBatch(tx time.Time, triples []Triple) int {
+	removed := db.remove(triples...)
+	for _, triple := range removed {
+		db.record(Datom{Triple: triple, Tx: tx})
+	}
+	if len(removed) > 0 && len(db.rules) > 0 {
+		db.evaluateRules()
+	}
+	return len(removed)
+}
+
+// Len reports the number of stored triples, excluding derived ones.
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.triples)
+}
+
+// Triples returns a copy of the stored triples, excluding derived ones.
+func (db *DB) Triples() []Triple {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return slices.Clone(db.triples)
+}
+
+// remove deletes triples and returns the stored versions of the ones that
+// were present.
+func (db *DB) remove(triples ...Triple) (removed []Triple) {
+	gone := make(map[Triple]struct{}, len(triples))
+	for _, triple := range triples {
+		key := tripleKey(triple)
+		if _, ok := db.present[key]; ok {
+			gone[key] = struct{}{}
+			delete(db.present, key)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+
+	isGone := func(triple Triple) bool {
+		_, ok := gone[tripleKey(triple)]
+		return ok
+	}
+	db.triples = slices.DeleteFunc(db.triples, func(triple Triple) bool {
+		if isGone(triple) {
+			removed = append(removed, triple)
+			return true
+		}
+		return false
+	})
+	for key := range gone {
+		removeFromIndex(db.entityIndex, key[0], isGone)
+		removeFromIndex(db.attrIndex, key[1], isGone)
+		removeFromIndex(db.valueIndex, key[2], isGone)
+	}
+	return removed
+}
+
+func removeFromIndex(index map[Value][]Triple, key Value, isGone func(Triple) bool) {
+	bucket := slices.DeleteFunc(index[key], isGone)
+	if len(bucket) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = bucket
+}
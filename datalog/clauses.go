@@ -0,0 +1,261 @@
+package datalog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Clause is a single condition in a query or rule body. It is implemented by
+// Pattern, Predicate and NotClause.
+type Clause interface {
+	// solve returns every extension of state that satisfies the clause,
+	// drawing candidate triples from source.
+	solve(db *DB, source tripleSource, state State) []State
+	// variables lists the variables referenced by the clause.
+	variables() []string
+}
+
+func (p Pattern) solve(db *DB, source tripleSource, state State) (valid []State) {
+	for triple := range source(bindPattern(p, state)) {
+		if newState := MatchPattern(p, triple, state); newState != nil {
+			valid = append(valid, newState)
+		}
+	}
+	return valid
+}
+
+func (p Pattern) variables() []string {
+	return variablesOf(p[:]...)
+}
+
+func variablesOf(parts ...Value) (vars []string) {
+	for _, part := range parts {
+		if isVariable(part) {
+			vars = append(vars, part.(string))
+		}
+	}
+	return vars
+}
+
+// Predicate operators understood by NewPredicate.
+const (
+	OpLess           = "<"
+	OpLessOrEqual    = "<="
+	OpGreater        = ">"
+	OpGreaterOrEqual = ">="
+	OpEqual          = "="
+	OpNotEqual       = "!="
+	OpPrefix         = "prefix"
+	OpRegex          = "regex"
+)
+
+// Predicate filters states by applying a built-in operator to its arguments.
+// Arguments may be constants or variables; a state in which a variable
+// argument is unbound never satisfies the predicate.
+type Predicate struct {
+	Op   string
+	Args []Value
+
+	compiled *compiledPredicate
+}
+
+// compiledPredicate holds the test for a predicate. Predicates built as
+// struct literals get one when a query is planned and compile it on first
+// use, so it is shared by every state the query visits.
+type compiledPredicate struct {
+	once sync.Once
+	test func(args []Value) bool
+	err  error
+}
+
+func (c *compiledPredicate) get(p Predicate) (func(args []Value) bool, error) {
+	c.once.Do(func() {
+		c.test, c.err = compilePredicate(p.Op, p.Args)
+	})
+	return c.test, c.err
+}
+
+// NewPredicate builds a predicate for one of the Op constants. Every operator
+// takes two arguments. For OpRegex the second argument is the expression and
+// is compiled up front when it is a constant.
+func NewPredicate(op string, args ...Value) (Predicate, error) {
+	p := Predicate{Op: op, Args: args, compiled: &compiledPredicate{}}
+	if _, err := p.compiled.get(p); err != nil {
+		return Predicate{}, err
+	}
+	return p, nil
+}
+
+func compilePredicate(op string, args []Value) (func(args []Value) bool, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("datalog: predicate %s takes 2 arguments, got %d", op, len(args))
+	}
+
+	switch op {
+	case OpLess:
+		return func(args []Value) bool { return Compare(args[0], args[1]) < 0 }, nil
+	case OpLessOrEqual:
+		return func(args []Value) bool { return Compare(args[0], args[1]) <= 0 }, nil
+	case OpGreater:
+		return func(args []Value) bool { return Compare(args[0], args[1]) > 0 }, nil
+	case OpGreaterOrEqual:
+		return func(args []Value) bool { return Compare(args[0], args[1]) >= 0 }, nil
+	case OpEqual:
+		return func(args []Value) bool { return Equal(args[0], args[1]) }, nil
+	case OpNotEqual:
+		return func(args []Value) bool { return !Equal(args[0], args[1]) }, nil
+	case OpPrefix:
+		return func(args []Value) bool {
+			s, ok := valueKey(args[0]).(string)
+			prefix, pok := valueKey(args[1]).(string)
+			return ok && pok && strings.HasPrefix(s, prefix)
+		}, nil
+	case OpRegex:
+		if isVariable(args[1]) {
+			return func(args []Value) bool {
+				re, err := compileRegex(FormatValue(args[1]))
+				return err == nil && re.MatchString(FormatValue(args[0]))
+			}, nil
+		}
+		re, err := compileRegex(FormatValue(args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("datalog: invalid regex predicate: %w", err)
+		}
+		return func(args []Value) bool { return re.MatchString(FormatValue(args[0])) }, nil
+	default:
+		return nil, fmt.Errorf("datalog: unknown predicate %q", op)
+	}
+}
+
+// regexes caches compiled regex predicates by expression, so expressions
+// bound from variables are compiled once rather than once per state.
+var regexes sync.Map
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexes.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexes.Store(expr, re)
+	return re, nil
+}
+
+func mustPredicate(op string, args ...Value) Predicate {
+	p, err := NewPredicate(op, args...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Lt is satisfied when a sorts before b.
+func Lt(a, b Value) Predicate { return mustPredicate(OpLess, a, b) }
+
+// Lte is satisfied when a sorts before or equal to b.
+func Lte(a, b Value) Predicate { return mustPredicate(OpLessOrEqual, a, b) }
+
+// Gt is satisfied when a sorts after b.
+func Gt(a, b Value) Predicate { return mustPredicate(OpGreater, a, b) }
+
+// Gte is satisfied when a sorts after or equal to b.
+func Gte(a, b Value) Predicate { return mustPredicate(OpGreaterOrEqual, a, b) }
+
+// Eq is satisfied when a and b are equal.
+func Eq(a, b Value) Predicate { return mustPredicate(OpEqual, a, b) }
+
+// Neq is satisfied when a and b differ.
+func Neq(a, b Value) Predicate { return mustPredicate(OpNotEqual, a, b) }
+
+// HasPrefix is satisfied when the string s starts with prefix.
+func HasPrefix(s, prefix Value) Predicate { return mustPredicate(OpPrefix, s, prefix) }
+
+// Matches is satisfied when the regular expression expr matches the
+// formatted value of v. It panics if expr is a constant that does not
+// compile.
+func Matches(v, expr Value) Predicate { return mustPredicate(OpRegex, v, expr) }
+
+func (p Predicate) solve(db *DB, source tripleSource, state State) []State {
+	args := make([]Value, len(p.Args))
+	for i, arg := range p.Args {
+		if !isVariable(arg) {
+			args[i] = arg
+			continue
+		}
+		bound, ok := state[arg.(string)]
+		if !ok {
+			return nil
+		}
+		args[i] = bound
+	}
+	compiled := p.compiled
+	if compiled == nil {
+		compiled = &compiledPredicate{}
+	}
+	test, err := compiled.get(p)
+	if err != nil || !test(args) {
+		return nil
+	}
+	return []State{state}
+}
+
+func (p Predicate) variables() []string {
+	return variablesOf(p.Args...)
+}
+
+// NotClause removes every state for which all of its clauses can be
+// satisfied. When used in rules, negated relations must not depend on the
+// rule itself, see AddRules.
+type NotClause struct {
+	Clauses []Clause
+}
+
+// Not negates the conjunction of clauses.
+func Not(clauses ...Clause) NotClause {
+	return NotClause{Clauses: clauses}
+}
+
+func (n NotClause) solve(db *DB, source tripleSource, state State) []State {
+	full := func(int) tripleSource { return db.allTriples }
+	if len(solveFrom(db, []State{state}, n.Clauses, full)) > 0 {
+		return nil
+	}
+	return []State{state}
+}
+
+func (n NotClause) variables() (vars []string) {
+	for _, clause := range n.Clauses {
+		vars = append(vars, clause.variables()...)
+	}
+	return vars
+}
+
+// prepare gives struct literal predicates, including those under a negation,
+// a compiledPredicate to share while the clause is evaluated.
+func prepare(clause Clause) Clause {
+	switch clause := clause.(type) {
+	case Predicate:
+		if clause.compiled == nil {
+			clause.compiled = &compiledPredicate{}
+		}
+		return clause
+	case NotClause:
+		inner := make([]Clause, len(clause.Clauses))
+		for i, c := range clause.Clauses {
+			inner[i] = prepare(c)
+		}
+		return NotClause{Clauses: inner}
+	default:
+		return clause
+	}
+}
+
+var (
+	_ Clause = Pattern{}
+	_ Clause = Predicate{}
+	_ Clause = NotClause{}
+)
@@ -2,20 +2,24 @@ package datalog
 
 import (
 	"iter"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-type Triple = [3]string
-type Pattern = [3]string
+type Triple = [3]Value
+type Pattern [3]Value
 
-func NewTriple(subject, predicate, object string) Triple {
+func NewTriple(subject, predicate string, object Value) Triple {
 	return Triple{subject, predicate, object}
 }
 
-type State map[string]string
+type State map[string]Value
 
-func isVariable(s string) bool {
-	return strings.HasPrefix(s, "?")
+func isVariable(v Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "?")
 }
 
 func deepCopyState(state State) State {
@@ -26,7 +30,7 @@ func deepCopyState(state State) State {
 	return newState
 }
 
-func matchVariable(variable, triplePart string, state State) State {
+func matchVariable(variable string, triplePart Value, state State) State {
 	bound, ok := state[variable]
 	if ok {
 		return matchPart(bound, triplePart, state)
@@ -36,14 +40,14 @@ func matchVariable(variable, triplePart string, state State) State {
 	return newState
 }
 
-func matchPart(patternPart, triplePart string, state State) State {
+func matchPart(patternPart, triplePart Value, state State) State {
 	if state == nil {
 		return nil
 	}
 	if isVariable(patternPart) {
-		return matchVariable(patternPart, triplePart, state)
+		return matchVariable(patternPart.(string), triplePart, state)
 	}
-	if patternPart == triplePart {
+	if Equal(patternPart, triplePart) {
 		return state
 	}
 	return nil
@@ -64,41 +68,139 @@ func MatchPattern(pattern Pattern, triple Triple, state State) State {
 }
 
 func (db *DB) QuerySingle(state State, pattern Pattern) (valid []State) {
-	for triple := range relevantTriples(db, pattern) {
-		newState := MatchPattern(pattern, triple, state)
-		if newState != nil {
-			valid = append(valid, newState)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.querySingle(state, pattern)
+}
+
+func (db *DB) querySingle(state State, pattern Pattern) (valid []State) {
+	return pattern.solve(db, db.allTriples, state)
+}
+
+// QueryWhere returns every state that satisfies all of the clauses. Clauses
+// can be patterns, predicates such as Lt or HasPrefix, or negations built with
+// Not. The clauses are reordered so the most selective patterns bind their
+// variables first; use Explain to see the chosen order.
+func (db *DB) QueryWhere(where ...Clause) []State {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.queryWhere(where...)
+}
+
+func (db *DB) queryWhere(where ...Clause) []State {
+	return solveFrom(db, []State{{}}, db.plan(where, nil).Clauses(), func(int) tripleSource { return db.allTriples })
+}
+
+// QueryWhereIter is like QueryWhere but yields the states lazily, joining the
+// clauses depth-first so that stopping early skips the remaining work. The
+// query runs on a copy of the triples taken when the loop starts, so the loop
+// body may query and modify db without affecting the states it is given.
+func (db *DB) QueryWhereIter(where ...Clause) iter.Seq[State] {
+	return func(yield func(State) bool) {
+		db.mu.RLock()
+		snapshot := db.snapshot()
+		db.mu.RUnlock()
+		solveDepthFirst(snapshot, State{}, snapshot.plan(where, nil).Clauses(), snapshot.allTriples, yield)
+	}
+}
+
+// solveDepthFirst extends state with each clause in turn and yields every
+// complete state, reporting false once yield asks to stop. Patterns read their
+// candidates straight from source; other clauses only filter state.
+func solveDepthFirst(db *DB, state State, clauses []Clause, source tripleSource, yield func(State) bool) bool {
+	if len(clauses) == 0 {
+		return yield(state)
+	}
+	clause, rest := clauses[0], clauses[1:]
+
+	if pattern, ok := clause.(Pattern); ok {
+		for triple := range source(bindPattern(pattern, state)) {
+			if next := MatchPattern(pattern, triple, state); next != nil {
+				if !solveDepthFirst(db, next, rest, source, yield) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	for _, next := range clause.solve(db, source, state) {
+		if !solveDepthFirst(db, next, rest, source, yield) {
+			return false
 		}
 	}
-	return valid
+	return true
 }
 
-func (db *DB) QueryWhere(where ...Pattern) []State {
-	states := []State{{}}
-	for _, pattern := range where {
+// solveFrom joins clauses left to right starting from states, drawing the
+// candidate triples for the clause at position i from sourceAt(i).
+func solveFrom(db *DB, states []State, clauses []Clause, sourceAt func(i int) tripleSource) []State {
+	for i, clause := range clauses {
+		source := sourceAt(i)
 		revised := make([]State, 0, len(states))
 		for _, state := range states {
-			revised = append(revised, db.QuerySingle(state, pattern)...)
+			revised = append(revised, clause.solve(db, source, state)...)
 		}
 		states = revised
+		if len(states) == 0 {
+			break
+		}
 	}
 	return states
 }
 
-func (db *DB) Query(find []string, where ...Pattern) [][]string {
-	states := db.QueryWhere(where...)
+// Query returns the find elements of every matching state, formatted as
+// strings. A find element is a variable, a constant or an aggregate such as
+// "count(?m)", see AggregateCount. Use QueryValues to keep the typed values.
+func (db *DB) Query(find []string, where ...Clause) [][]string {
+	rows := db.QueryValues(find, where...)
 
-	results := make([][]string, len(states))
-	for i, state := range states {
-		results[i] = actualize(state, find...)
+	results := make([][]string, len(rows))
+	for i, row := range rows {
+		results[i] = make([]string, len(row))
+		for j, value := range row {
+			results[i][j] = FormatValue(value)
+		}
 	}
 	return results
 }
 
-func actualize(state State, find ...string) []string {
-	results := make([]string, len(find))
+// QueryValues is like Query but returns the bound values with their types.
+// Aggregates produce an int64 for count, an int64 or float64 for sum, and a
+// sorted []Value for distinct.
+func (db *DB) QueryValues(find []string, where ...Clause) [][]Value {
+	return project(db.QueryWhere(where...), find)
+}
+
+// QueryIter is like QueryValues but yields the rows lazily, see
+// QueryWhereIter. Aggregates need every matching state before the first row
+// is known, so a find spec with an aggregate is evaluated eagerly.
+func (db *DB) QueryIter(find []string, where ...Clause) iter.Seq[[]Value] {
+	return func(yield func([]Value) bool) {
+		for _, f := range find {
+			if parseFindSpec(f).aggregate == "" {
+				continue
+			}
+			for _, row := range db.QueryValues(find, where...) {
+				if !yield(row) {
+					return
+				}
+			}
+			return
+		}
+
+		for state := range db.QueryWhereIter(where...) {
+			if !yield(actualizeValues(state, find...)) {
+				return
+			}
+		}
+	}
+}
+
+func actualizeValues(state State, find ...string) []Value {
+	results := make([]Value, len(find))
 	for i, findPart := range find {
-		r := findPart
+		var r Value = findPart
 		if isVariable(findPart) {
 			r = state[findPart]
 		}
@@ -108,62 +210,169 @@ func actualize(state State, find ...string) []string {
 }
 
 type DB struct {
+	mu          sync.RWMutex
 	triples     []Triple
-	entityIndex map[string][]Triple
-	attrIndex   map[string][]Triple
-	valueIndex  map[string][]Triple
+	present     map[Triple]struct{}
+	entityIndex map[Value][]Triple
+	attrIndex   map[Value][]Triple
+	valueIndex  map[Value][]Triple
+
+	rules   []Rule
+	strata  [][]Rule
+	derived *DB
+
+	// history turns on recording changes in log, ordered by transaction time,
+	// see CreateDBWithHistory. lastTx is the time of the latest change.
+	history bool
+	log     []Datom
+	lastTx  time.Time
 }
 
+// CreateDB builds a database from triples. Values are normalized, so for
+// example an int is stored as an int64. It panics with ErrInvalidValue if a
+// triple can not be stored.
 func CreateDB(triples ...Triple) *DB {
+	mustCheckTriples(triples)
+	return newDB(triples)
+}
+
+// CreateDBWithHistory is like CreateDB but also records every assert and
+// retract, so the database can be queried with AsOf and History. The history
+// grows with every change, use CompactHistory to bound it.
+func CreateDBWithHistory(triples ...Triple) *DB {
+	mustCheckTriples(triples)
+	db := newDB(triples)
+	db.history = true
+	tx := time.Now()
+	db.lastTx = tx
+	db.log = make([]Datom, len(db.triples))
+	for i, triple := range db.triples {
+		db.log[i] = Datom{Triple: triple, Tx: tx, Added: true}
+	}
+	return db
+}
+
+// newDB builds a database without recording history, for derived and
+// intermediate results.
+func newDB(triples []Triple) *DB {
+	triples = slices.Clone(triples)
+	present := make(map[Triple]struct{}, len(triples))
+	for i, triple := range triples {
+		triples[i] = normalizeTriple(triple)
+		present[tripleKey(triple)] = struct{}{}
+	}
 	return &DB{
 		triples:     triples,
+		present:     present,
 		entityIndex: indexBy(triples, 0),
 		attrIndex:   indexBy(triples, 1),
 		valueIndex:  indexBy(triples, 2),
 	}
 }
 
-func indexBy(triples []Triple, idx int) map[string][]Triple {
-	index := map[string][]Triple{}
+// snapshot copies the stored and derived triples along with their indexes,
+// so they can be queried without holding the lock.
+func (db *DB) snapshot() *DB {
+	if db == nil {
+		return nil
+	}
+	return &DB{
+		triples:     slices.Clone(db.triples),
+		entityIndex: cloneIndex(db.entityIndex),
+		attrIndex:   cloneIndex(db.attrIndex),
+		valueIndex:  cloneIndex(db.valueIndex),
+		derived:     db.derived.snapshot(),
+	}
+}
+
+func cloneIndex(index map[Value][]Triple) map[Value][]Triple {
+	clone := make(map[Value][]Triple, len(index))
+	for key, bucket := range index {
+		clone[key] = slices.Clone(bucket)
+	}
+	return clone
+}
+
+func indexBy(triples []Triple, idx int) map[Value][]Triple {
+	index := map[Value][]Triple{}
 	for _, triple := range triples {
-		key := triple[idx]
+		key := valueKey(triple[idx])
 		index[key] = append(index[key], triple)
 	}
 	return index
 }
 
+func (db *DB) has(triple Triple) bool {
+	_, ok := db.present[tripleKey(triple)]
+	return ok
+}
+
+func (db *DB) insert(triples ...Triple) {
+	for _, triple := range triples {
+		triple = normalizeTriple(triple)
+		key := tripleKey(triple)
+		db.present[key] = struct{}{}
+		db.triples = append(db.triples, triple)
+		db.entityIndex[key[0]] = append(db.entityIndex[key[0]], triple)
+		db.attrIndex[key[1]] = append(db.attrIndex[key[1]], triple)
+		db.valueIndex[key[2]] = append(db.valueIndex[key[2]], triple)
+	}
+}
+
+func (db *DB) allTriples(pattern Pattern) iter.Seq[Triple] {
+	return relevantTriples(db, pattern)
+}
+
+// relevantTriples yields the stored triples that could match pattern followed
+// by any triples derived from rules.
 func relevantTriples(db *DB, pattern Pattern) iter.Seq[Triple] {
 	return func(yield func(Triple) bool) {
-		id, attr, value := pattern[0], pattern[1], pattern[2]
-		if !isVariable(id) {
-			for _, triple := range db.entityIndex[id] {
-				if !yield(triple) {
-					return
-				}
+		for triple := range indexedTriples(db, pattern) {
+			if !yield(triple) {
+				return
 			}
+		}
+		if db.derived == nil {
 			return
 		}
-		if !isVariable(attr) {
-			for _, triple := range db.attrIndex[attr] {
-				if !yield(triple) {
-					return
-				}
+		for triple := range indexedTriples(db.derived, pattern) {
+			if !yield(triple) {
+				return
 			}
-			return
 		}
-		if !isVariable(value) {
-			for _, triple := range db.valueIndex[value] {
-				if !yield(triple) {
-					return
-				}
+	}
+}
+
+// indexedTriples yields the stored triples that could match pattern, using
+// the smallest index bucket among the constant positions.
+func indexedTriples(db *DB, pattern Pattern) iter.Seq[Triple] {
+	return func(yield func(Triple) bool) {
+		candidates := db.triples
+		for i, part := range pattern {
+			if isVariable(part) {
+				continue
+			}
+			if bucket := db.index(i)[valueKey(part)]; len(bucket) < len(candidates) {
+				candidates = bucket
 			}
-			return
 		}
 
-		for _, triple := range db.triples {
+		for _, triple := range candidates {
 			if !yield(triple) {
 				return
 			}
 		}
 	}
 }
+
+// index returns the index for the given triple position.
+func (db *DB) index(position int) map[Value][]Triple {
+	switch position {
+	case 0:
+		return db.entityIndex
+	case 1:
+		return db.attrIndex
+	default:
+		return db.valueIndex
+	}
+}
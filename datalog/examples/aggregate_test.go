@@ -0,0 +1,58 @@
+package examples
+
+import (
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateCountGroupedByDirector(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	actual := db.Query(
+		[]string{"?directorName", "count(?m)"},
+		datalog.Pattern{"?m", "movie/director", "?d"},
+		datalog.Pattern{"?d", "person/name", "?directorName"},
+		datalog.HasPrefix("?directorName", "James"),
+	)
+	assert.Equal(t, [][]string{{"James Cameron", "3"}}, actual)
+}
+
+func TestAggregateSumMinMaxDistinct(t *testing.T) {
+	db := datalog.CreateDB(typedYears()...)
+
+	actual := db.QueryValues(
+		[]string{"count(?m)", "min(?year)", "max(?year)", "sum(?year)"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+		datalog.Lt("?year", 1981),
+	)
+	assert.Equal(t, [][]datalog.Value{
+		{int64(2), int64(1979), int64(1979), int64(3958)},
+	}, actual)
+
+	years := db.QueryValues(
+		[]string{"?directorName", "distinct(?year)"},
+		datalog.Pattern{"?d", "person/name", "George Miller"},
+		datalog.Pattern{"?m", "movie/director", "?d"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+		datalog.Pattern{"?d", "person/name", "?directorName"},
+	)
+	assert.Equal(t, [][]datalog.Value{
+		{"George Miller", []datalog.Value{int64(1979), int64(1981)}},
+	}, years)
+	assert.Equal(t, [][]string{{"[1979 1981]"}}, db.Query(
+		[]string{"distinct(?year)"},
+		datalog.Pattern{"?d", "person/name", "George Miller"},
+		datalog.Pattern{"?m", "movie/director", "?d"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+	))
+}
+
+func TestAggregateOverNoStates(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	actual := db.Query(
+		[]string{"count(?m)"},
+		datalog.Pattern{"?m", "movie/title", "Not A Movie"},
+	)
+	assert.Empty(t, actual)
+}
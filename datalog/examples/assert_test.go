@@ -0,0 +1,99 @@
+package examples
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertAndRetractKeepIndexesConsistent(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+
+	assert.True(t, db.Assert(datalog.Triple{"220", "movie/title", "The Abyss"}))
+	assert.False(t, db.Assert(datalog.Triple{"220", "movie/title", "The Abyss"}))
+	assert.Equal(t, 2, db.AssertBatch(
+		datalog.Triple{"220", "movie/year", "1989"},
+		datalog.Triple{"220", "movie/director", "100"},
+	))
+
+	directed := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?directorId", "person/name", "James Cameron"},
+		datalog.Pattern{"?movieId", "movie/director", "?directorId"},
+		datalog.Pattern{"?movieId", "movie/title", "?title"},
+	)
+	assert.Contains(t, directed, []string{"The Abyss"})
+	assert.Equal(t, [][]string{{"220"}}, db.Query(
+		[]string{"?movieId"},
+		datalog.Pattern{"?movieId", "?attr", "1989"},
+		datalog.Pattern{"?movieId", "movie/title", "The Abyss"},
+	))
+
+	assert.True(t, db.Retract(datalog.Triple{"220", "movie/director", "100"}))
+	assert.False(t, db.Retract(datalog.Triple{"220", "movie/director", "100"}))
+	assert.Empty(t, db.Query(
+		[]string{"?movieId"},
+		datalog.Pattern{"?movieId", "movie/director", "100"},
+		datalog.Pattern{"?movieId", "movie/title", "The Abyss"},
+	))
+	assert.Equal(t, 1, db.RetractBatch(
+		datalog.Triple{"220", "movie/title", "The Abyss"},
+		datalog.Triple{"220", "movie/title", "Not Stored"},
+	))
+	assert.Empty(t, db.QuerySingle(datalog.State{}, datalog.Pattern{"220", "movie/title", "?title"}))
+	assert.Equal(t, len(Movies)+1, db.Len())
+}
+
+func TestAssertAndRetractUpdateRules(t *testing.T) {
+	db := datalog.CreateDB(
+		datalog.Triple{"a", "edge", "b"},
+		datalog.Triple{"b", "edge", "c"},
+	)
+	require.NoError(t, db.AddRules(
+		datalog.NewRule(datalog.Pattern{"?x", "reachable", "?y"}, datalog.Pattern{"?x", "edge", "?y"}),
+		datalog.NewRule(
+			datalog.Pattern{"?x", "reachable", "?z"},
+			datalog.Pattern{"?x", "edge", "?y"},
+			datalog.Pattern{"?y", "reachable", "?z"},
+		),
+	))
+
+	reachable := func() [][]string {
+		return db.Query([]string{"?y"}, datalog.Pattern{"a", "reachable", "?y"})
+	}
+	assert.ElementsMatch(t, [][]string{{"b"}, {"c"}}, reachable())
+
+	db.Assert(datalog.Triple{"c", "edge", "d"})
+	assert.ElementsMatch(t, [][]string{{"b"}, {"c"}, {"d"}}, reachable())
+
+	db.Retract(datalog.Triple{"b", "edge", "c"})
+	assert.ElementsMatch(t, [][]string{{"b"}}, reachable())
+}
+
+func TestAssertConcurrently(t *testing.T) {
+	db := datalog.CreateDB()
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				id := fmt.Sprintf("%d-%d", w, i)
+				db.Assert(datalog.Triple{id, "worker", fmt.Sprint(w)})
+				db.QuerySingle(datalog.State{}, datalog.Pattern{"?id", "worker", fmt.Sprint(w)})
+				if i%2 == 0 {
+					db.Retract(datalog.Triple{id, "worker", fmt.Sprint(w)})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 400, db.Len())
+	assert.Len(t, db.QueryWhere(datalog.Pattern{"?id", "worker", "3"}), 50)
+}
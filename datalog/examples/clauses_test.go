@@ -0,0 +1,151 @@
+package examples
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// typedYears returns the movie triples with movie/year stored as an int64.
+func typedYears() []datalog.Triple {
+	triples := make([]datalog.Triple, len(Movies))
+	for i, triple := range Movies {
+		if triple[1] == "movie/year" {
+			year, _ := strconv.ParseInt(triple[2].(string), 10, 64)
+			triple[2] = year
+		}
+		triples[i] = triple
+	}
+	return triples
+}
+
+func TestPredicatesAndNegation(t *testing.T) {
+	db := datalog.CreateDB(typedYears()...)
+
+	actual := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+		datalog.Gt("?year", 1990),
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Pattern{"?arnold", "person/name", "Arnold Schwarzenegger"},
+		datalog.Not(datalog.Pattern{"?m", "movie/cast", "?arnold"}),
+	)
+
+	assert.ElementsMatch(t, [][]string{
+		{"Lethal Weapon 3"},
+		{"Braveheart"},
+	}, actual)
+}
+
+func TestPrefixRegexAndNotEqualPredicates(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+
+	prefixed := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.HasPrefix("?title", "Terminator"),
+	)
+	assert.Equal(t, [][]string{
+		{"Terminator 2: Judgment Day"},
+		{"Terminator 3: Rise of the Machines"},
+	}, prefixed)
+
+	matched := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Matches("?title", `^Rambo|^Mad Max \d`),
+	)
+	assert.Equal(t, [][]string{
+		{"Rambo: First Blood Part II"},
+		{"Rambo III"},
+		{"Mad Max 2"},
+	}, matched)
+
+	costars := db.Query(
+		[]string{"?name"},
+		datalog.Pattern{"?mel", "person/name", "Mel Gibson"},
+		datalog.Pattern{"?m", "movie/title", "Braveheart"},
+		datalog.Pattern{"?m", "movie/cast", "?actor"},
+		datalog.Neq("?actor", "?mel"),
+		datalog.Pattern{"?actor", "person/name", "?name"},
+	)
+	assert.Equal(t, [][]string{{"Sophie Marceau"}}, costars)
+
+	_, err := datalog.NewPredicate(datalog.OpRegex, "?title", "(")
+	assert.Error(t, err)
+	_, err = datalog.NewPredicate("between", "?year", 1, 2)
+	assert.Error(t, err)
+}
+
+func TestStratifiedNegationInRules(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	require.NoError(t, db.AddRules(
+		datalog.NewRule(
+			datalog.Pattern{"?m", "movie/has-sequel", true},
+			datalog.Pattern{"?m", "movie/sequel", "?s"},
+		),
+		datalog.NewRule(
+			datalog.Pattern{"?m", "movie/standalone", true},
+			datalog.Pattern{"?m", "movie/title", "?title"},
+			datalog.Not(datalog.Pattern{"?m", "movie/has-sequel", true}),
+			datalog.Not(datalog.Pattern{"?prequel", "movie/sequel", "?m"}),
+		),
+	))
+
+	actual := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/standalone", true},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+	)
+	assert.ElementsMatch(t, [][]string{
+		{"RoboCop"},
+		{"Commando"},
+		{"Die Hard"},
+		{"Braveheart"},
+	}, actual)
+
+	db.Assert(datalog.Triple{"204", "movie/sequel", "999"})
+	actual = db.Query(
+		[]string{"?m"},
+		datalog.Pattern{"?m", "movie/standalone", true},
+		datalog.Pattern{"?m", "movie/title", "RoboCop"},
+	)
+	assert.Empty(t, actual)
+}
+
+func TestNegationMustBeStratified(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	err := db.AddRules(datalog.NewRule(
+		datalog.Pattern{"?m", "movie/odd", true},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Not(datalog.Pattern{"?m", "movie/odd", true}),
+	))
+	assert.ErrorIs(t, err, datalog.ErrNotStratifiable)
+}
+
+func TestPredicateStructLiterals(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+
+	// Predicates built without NewPredicate are compiled when first used,
+	// including regexes bound from variables and predicates under a negation.
+	db.Assert(datalog.Triple{"filter", "filter/expr", `^Rambo`})
+	actual := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"filter", "filter/expr", "?expr"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Predicate{Op: datalog.OpRegex, Args: []datalog.Value{"?title", "?expr"}},
+		datalog.Not(datalog.Predicate{Op: datalog.OpPrefix, Args: []datalog.Value{"?title", "Rambo III"}}),
+	)
+	assert.Equal(t, [][]string{{"Rambo: First Blood Part II"}}, actual)
+
+	// A struct literal with an unknown operator matches nothing.
+	actual = db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Predicate{Op: "between", Args: []datalog.Value{"?title", "a"}},
+	)
+	assert.Empty(t, actual)
+}
@@ -0,0 +1,156 @@
+package examples
+
+import (
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsOfAndHistory(t *testing.T) {
+	db := datalog.CreateDBWithHistory(Movies...)
+
+	t1 := time.Now().Add(time.Hour)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	assertAt(t, db, t1, 2,
+		datalog.Triple{"220", "movie/title", "The Abyss"},
+		datalog.Triple{"220", "movie/year", 1988},
+	)
+	retractAt(t, db, t2, 1, datalog.Triple{"220", "movie/year", 1988})
+	assertAt(t, db, t2, 1, datalog.Triple{"220", "movie/year", 1989})
+	retractAt(t, db, t3, 1, datalog.Triple{"220", "movie/title", "The Abyss"})
+
+	year := datalog.Pattern{"220", "movie/year", "?year"}
+	title := datalog.Pattern{"220", "movie/title", "?title"}
+
+	before := asOf(t, db, t1.Add(-time.Minute))
+	assert.Empty(t, before.QuerySingle(datalog.State{}, year))
+	assert.Equal(t, len(Movies), before.Len())
+
+	atT1 := asOf(t, db, t1)
+	assert.Equal(t, []datalog.State{{"?year": int64(1988)}}, atT1.QuerySingle(datalog.State{}, year))
+	assert.Equal(t, []datalog.State{{"?title": "The Abyss"}}, atT1.QuerySingle(datalog.State{}, title))
+
+	atT2 := asOf(t, db, t2)
+	assert.Equal(t, []datalog.State{{"?year": int64(1989)}}, atT2.QuerySingle(datalog.State{}, year))
+	assert.Equal(t, []datalog.State{{"?title": "The Abyss"}}, atT2.QuerySingle(datalog.State{}, title))
+
+	atT3 := asOf(t, db, t3)
+	assert.Empty(t, atT3.QuerySingle(datalog.State{}, title))
+	assert.Equal(t, db.Triples(), atT3.Triples())
+
+	history := db.History("220")
+	require.Len(t, history, 5)
+	assert.Equal(t, datalog.Datom{Triple: datalog.Triple{"220", "movie/title", "The Abyss"}, Tx: t1.UTC(), Added: true}, withUTC(history[0]))
+	assert.Equal(t, datalog.Datom{Triple: datalog.Triple{"220", "movie/year", int64(1988)}, Tx: t2.UTC(), Added: false}, withUTC(history[2]))
+	assert.False(t, history[4].Added)
+
+	// Snapshots are independent of later changes.
+	assertAt(t, db, t3, 1, datalog.Triple{"220", "movie/director", "100"})
+	assert.Empty(t, atT1.QuerySingle(datalog.State{}, datalog.Pattern{"220", "movie/director", "?d"}))
+}
+
+func TestAsOfEvaluatesRules(t *testing.T) {
+	db := datalog.CreateDBWithHistory(
+		datalog.Triple{"a", "parent", "b"},
+	)
+	require.NoError(t, db.AddRules(
+		datalog.NewRule(
+			datalog.Pattern{"?x", "ancestor", "?y"},
+			datalog.Pattern{"?x", "parent", "?y"},
+		),
+		datalog.NewRule(
+			datalog.Pattern{"?x", "ancestor", "?z"},
+			datalog.Pattern{"?x", "parent", "?y"},
+			datalog.Pattern{"?y", "ancestor", "?z"},
+		),
+	))
+
+	later := time.Now().Add(time.Hour)
+	assertAt(t, db, later, 1, datalog.Triple{"b", "parent", "c"})
+
+	ancestors := datalog.Pattern{"a", "ancestor", "?who"}
+	assert.Equal(t, []datalog.State{{"?who": "b"}}, asOf(t, db, later.Add(-time.Minute)).QuerySingle(datalog.State{}, ancestors))
+	assert.ElementsMatch(t, []datalog.State{{"?who": "b"}, {"?who": "c"}}, asOf(t, db, later).QuerySingle(datalog.State{}, ancestors))
+}
+
+func TestTxMustNotGoBackwards(t *testing.T) {
+	db := datalog.CreateDBWithHistory()
+	now := time.Now()
+	fact := datalog.Triple{"a", "likes", "b"}
+
+	assertAt(t, db, now.Add(10*time.Hour), 1, fact)
+	n, err := db.RetractBatchAt(now.Add(5*time.Hour), fact)
+	require.ErrorIs(t, err, datalog.ErrTxOutOfOrder)
+	assert.Zero(t, n)
+	assert.Equal(t, []datalog.Triple{fact}, db.Triples())
+
+	// A change without a tx is not recorded before the latest one.
+	assert.Equal(t, 1, db.RetractBatch(fact))
+	assert.Empty(t, db.Triples())
+	assert.Empty(t, asOf(t, db, now.Add(10*time.Hour)).Triples())
+	history := db.History("a")
+	require.Len(t, history, 2)
+	assert.False(t, history[1].Added)
+	assert.False(t, history[1].Tx.Before(history[0].Tx))
+}
+
+func TestHistoryIsOptIn(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	db.AssertBatch(datalog.Triple{"220", "movie/title", "The Abyss"})
+	db.RetractBatch(datalog.Triple{"220", "movie/title", "The Abyss"})
+	assert.Empty(t, db.History("220"))
+	_, err := db.AsOf(time.Now())
+	assert.ErrorIs(t, err, datalog.ErrNoHistory)
+}
+
+func TestCompactHistory(t *testing.T) {
+	db := datalog.CreateDBWithHistory()
+	t1 := time.Now().Add(time.Hour)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	assertAt(t, db, t1, 1, datalog.Triple{"220", "movie/year", 1988})
+	retractAt(t, db, t2, 1, datalog.Triple{"220", "movie/year", 1988})
+	assertAt(t, db, t2, 1, datalog.Triple{"220", "movie/year", 1989})
+	assertAt(t, db, t3, 1, datalog.Triple{"220", "movie/title", "The Abyss"})
+
+	assert.Equal(t, 2, db.CompactHistory(t2))
+	history := db.History("220")
+	require.Len(t, history, 2)
+	assert.Equal(t, datalog.Triple{"220", "movie/year", int64(1989)}, history[0].Triple)
+	assert.Equal(t, datalog.Triple{"220", "movie/title", "The Abyss"}, history[1].Triple)
+
+	assert.ElementsMatch(t, db.Triples(), asOf(t, db, t3).Triples())
+	assert.Equal(t, []datalog.Triple{{"220", "movie/year", int64(1989)}}, asOf(t, db, t2).Triples())
+}
+
+func withUTC(d datalog.Datom) datalog.Datom {
+	d.Tx = d.Tx.UTC()
+	return d
+}
+
+func assertAt(t *testing.T, db *datalog.DB, tx time.Time, want int, triples ...datalog.Triple) {
+	t.Helper()
+	n, err := db.AssertBatchAt(tx, triples...)
+	require.NoError(t, err)
+	assert.Equal(t, want, n)
+}
+
+func retractAt(t *testing.T, db *datalog.DB, tx time.Time, want int, triples ...datalog.Triple) {
+	t.Helper()
+	n, err := db.RetractBatchAt(tx, triples...)
+	require.NoError(t, err)
+	assert.Equal(t, want, n)
+}
+
+func asOf(t *testing.T, db *datalog.DB, tx time.Time) *datalog.DB {
+	t.Helper()
+	snapshot, err := db.AsOf(tx)
+	require.NoError(t, err)
+	return snapshot
+}
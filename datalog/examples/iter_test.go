@@ -0,0 +1,77 @@
+package examples
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryWhereIterMatchesQueryWhere(t *testing.T) {
+	db := datalog.CreateDB(typedYears()...)
+	where := []datalog.Clause{
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+		datalog.Lt("?year", 1990),
+		datalog.Not(datalog.Pattern{"?m", "movie/sequel", "?sequel"}),
+	}
+
+	assert.Equal(t, db.QueryWhere(where...), slices.Collect(db.QueryWhereIter(where...)))
+	assert.Equal(t,
+		db.QueryValues([]string{"?title", "?year"}, where...),
+		slices.Collect(db.QueryIter([]string{"?title", "?year"}, where...)),
+	)
+	assert.Equal(t,
+		db.QueryValues([]string{"count(?m)"}, where...),
+		slices.Collect(db.QueryIter([]string{"count(?m)"}, where...)),
+	)
+}
+
+func TestQueryIterStopsEarly(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+
+	find := []string{"?title"}
+	where := datalog.Pattern{"?m", "movie/title", "?title"}
+
+	var titles [][]datalog.Value
+	for row := range db.QueryIter(find, where) {
+		titles = append(titles, row)
+		if len(titles) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, db.QueryValues(find, where)[:2], titles)
+
+	// The read lock is released once the loop exits.
+	assert.True(t, db.Assert(datalog.Triple{"220", "movie/title", "The Abyss"}))
+}
+
+func TestQueryIterRunsOnASnapshot(t *testing.T) {
+	db := datalog.CreateDB(
+		datalog.Triple{"a", "friend", "b"},
+		datalog.Triple{"b", "friend", "c"},
+		datalog.Triple{"c", "friend", "d"},
+	)
+	where := []datalog.Clause{
+		datalog.Pattern{"?x", "friend", "?y"},
+		datalog.Pattern{"?y", "friend", "?z"},
+	}
+
+	var rows [][]datalog.Value
+	for row := range db.QueryIter([]string{"?x", "?z"}, where...) {
+		if len(rows) == 0 {
+			// The loop body can query and modify db without changing the
+			// rows still to come.
+			assert.Len(t, db.QueryWhere(where...), 2)
+			assert.True(t, db.Assert(datalog.Triple{"d", "friend", "e"}))
+			assert.Equal(t, 2, db.RetractBatch(
+				datalog.Triple{"b", "friend", "c"},
+				datalog.Triple{"c", "friend", "d"},
+			))
+		}
+		rows = append(rows, row)
+	}
+	assert.Equal(t, [][]datalog.Value{{"a", "c"}, {"b", "d"}}, rows)
+	assert.Empty(t, db.QueryWhere(where...))
+}
@@ -0,0 +1,98 @@
+package examples
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueryMatchesGoQuery(t *testing.T) {
+	db := datalog.CreateDB(typedYears()...)
+
+	actual, err := db.QueryString(`
+		[:find ?title
+		 :where [?m :movie/year ?year]
+		        [(> ?year 1990)]
+		        [?m "movie/title" ?title]
+		        [?arnold :person/name "Arnold Schwarzenegger"]
+		        (not [?m :movie/cast ?arnold])] ; skip Arnold's movies
+	`)
+	require.NoError(t, err)
+
+	expected := db.QueryValues(
+		[]string{"?title"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+		datalog.Gt("?year", 1990),
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Pattern{"?arnold", "person/name", "Arnold Schwarzenegger"},
+		datalog.Not(datalog.Pattern{"?m", "movie/cast", "?arnold"}),
+	)
+	assert.NotEmpty(t, actual)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseQueryValues(t *testing.T) {
+	q, err := datalog.ParseQuery(`:find ?e (count ?v) :where [?e :a/b -12] [?e "x" 1.5], [?e #ref "100" true] [?e :when #inst "1985-06-07T00:00:00Z"] [?e :v ?v]`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"?e", "count(?v)"}, q.Find)
+	assert.Equal(t, []datalog.Clause{
+		datalog.Pattern{"?e", "a/b", int64(-12)},
+		datalog.Pattern{"?e", "x", 1.5},
+		datalog.Pattern{"?e", datalog.Ref("100"), true},
+		datalog.Pattern{"?e", "when", time.Date(1985, 6, 7, 0, 0, 0, 0, time.UTC)},
+		datalog.Pattern{"?e", "v", "?v"},
+	}, q.Where)
+}
+
+func TestParseQueryQuotedStringsAreConstants(t *testing.T) {
+	db := datalog.CreateDB(
+		datalog.NewTriple("1", "question", "?y"),
+		datalog.NewTriple("2", "question", "why"),
+	)
+
+	q, err := datalog.ParseQuery(`[:find ?e :where [?e :question "?y"]]`)
+	require.NoError(t, err)
+	assert.Equal(t, []datalog.Clause{datalog.Pattern{"?e", "question", datalog.Literal("?y")}}, q.Where)
+
+	rows, err := db.QueryString(`[:find ?e :where [?e :question "?y"]]`)
+	require.NoError(t, err)
+	assert.Equal(t, [][]datalog.Value{{"1"}}, rows)
+
+	rows, err = db.QueryString(`[:find ?e :where [?e :question ?q] [(= ?q "?y")]]`)
+	require.NoError(t, err)
+	assert.Equal(t, [][]datalog.Value{{"1"}}, rows)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src          string
+		line, column int
+		msg          string
+	}{
+		{`[:where [?m "a" ?v]]`, 1, 2, `expected :find, found keyword :where`},
+		{`[:find ?v :where [?m "a"]]`, 1, 18, `pattern has 2 elements, want 3`},
+		{"[:find ?v\n :where [?m \"a\" ?v ?x]]", 2, 20, `expected "]" after 3 pattern elements, found symbol ?x`},
+		{`[:find ?v :where [?m "a" ?v] [(~ ?v 1)]]`, 1, 32, `unknown predicate "~"`},
+		{`[:find ?v :where [?m "a" "unterminated]]`, 1, 26, `unterminated string`},
+		{`[:find (median ?v) :where [?m "a" ?v]]`, 1, 9, `unknown aggregate median`},
+		{`[:find ?x :where [?m "a" ?v]]`, 1, 8, `variable ?x is not bound by any where clause`},
+		{`[:find ?v :where [?m "a" ?v]] extra`, 1, 31, `expected end of query, found symbol extra`},
+		{`[:find ?v :where [?m "a" ?v] (or [?m "b" ?v])]`, 1, 31, `expected not, found symbol or`},
+		{`[:find ?v :where [?m "a" ?v]`, 1, 29, `expected "]" to close the query, found end of query`},
+		{`[:find ?v :where [?m "a" "?v"]]`, 1, 8, `variable ?v is not bound by any where clause`},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			_, err := datalog.ParseQuery(tc.src)
+			var syntaxErr *datalog.SyntaxError
+			require.True(t, errors.As(err, &syntaxErr), "got %v", err)
+			assert.Equal(t, tc.line, syntaxErr.Line)
+			assert.Equal(t, tc.column, syntaxErr.Column)
+			assert.Equal(t, tc.msg, syntaxErr.Msg)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package examples
+
+import (
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlannerBindsSelectiveClausesFirst(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	where := []datalog.Clause{
+		datalog.Pattern{"?movieId", "movie/title", "?movieTitle"},
+		datalog.Pattern{"?movieId", "movie/cast", "?arnoldId"},
+		datalog.Neq("?movieTitle", "Predator"),
+		datalog.Pattern{"?arnoldId", "person/name", "Arnold Schwarzenegger"},
+	}
+
+	plan := db.Explain(where...)
+	positions := make([]int, len(plan))
+	for i, step := range plan {
+		positions[i] = step.Position
+	}
+	assert.Equal(t, []int{3, 1, 0, 2}, positions)
+	assert.Equal(t, "value", plan[0].Access)
+	assert.Equal(t, 1.0, plan[0].Estimate)
+	assert.Equal(t, "filter", plan[3].Access)
+	assert.Contains(t, plan.String(), `1. [?arnoldId "person/name" "Arnold Schwarzenegger"] via value (~1.0) [clause 4]`)
+
+	actual := db.Query([]string{"?movieTitle"}, where...)
+	assert.Equal(t, [][]string{
+		{"The Terminator"},
+		{"Commando"},
+		{"Terminator 2: Judgment Day"},
+		{"Terminator 3: Rise of the Machines"},
+	}, actual)
+}
+
+func TestPlannerWaitsForSharedNegationVariables(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	plan := db.Explain(
+		datalog.Not(datalog.Pattern{"?m", "movie/sequel", "?s"}),
+		datalog.Pattern{"?m", "movie/year", "1987"},
+	)
+	assert.Equal(t, 1, plan[0].Position)
+	assert.Equal(t, "not", plan[1].Access)
+
+	actual := db.Query(
+		[]string{"?m"},
+		datalog.Not(datalog.Pattern{"?m", "movie/sequel", "?s"}),
+		datalog.Pattern{"?m", "movie/year", "1987"},
+	)
+	assert.Equal(t, [][]string{{"204"}}, actual)
+}
+
+func TestStats(t *testing.T) {
+	db := datalog.CreateDB(
+		datalog.Triple{"a", "edge", "b"},
+		datalog.Triple{"b", "edge", "c"},
+		datalog.Triple{"a", "name", "A"},
+	)
+	stats := db.Stats()
+	assert.Equal(t, 3, stats.Triples)
+	assert.Equal(t, 2, stats.Entity.Keys)
+	assert.Equal(t, 2, stats.Attribute.Keys)
+	assert.Equal(t, 3, stats.Value.Keys)
+	assert.Equal(t, 1.5, stats.Attribute.AverageBucket(stats.Triples))
+}
@@ -0,0 +1,80 @@
+package examples
+
+import (
+	"testing"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesTransitiveSequels(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	require.NoError(t, db.AddRules(
+		datalog.NewRule(
+			datalog.Pattern{"?a", "movie/follows", "?b"},
+			datalog.Pattern{"?a", "movie/sequel", "?b"},
+		),
+		datalog.NewRule(
+			datalog.Pattern{"?a", "movie/follows", "?c"},
+			datalog.Pattern{"?a", "movie/sequel", "?b"},
+			datalog.Pattern{"?b", "movie/follows", "?c"},
+		),
+	))
+
+	actual := db.Query(
+		[]string{"?title"},
+		datalog.Pattern{"?first", "movie/title", "The Terminator"},
+		datalog.Pattern{"?first", "movie/follows", "?m"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+	)
+
+	expected := [][]string{
+		{"Terminator 2: Judgment Day"},
+		{"Terminator 3: Rise of the Machines"},
+	}
+	assert.ElementsMatch(t, expected, actual)
+}
+
+func TestRulesActorsConnectedWithinTwoHops(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	require.NoError(t, db.AddRules(
+		datalog.NewRule(
+			datalog.Pattern{"?a", "person/costar", "?b"},
+			datalog.Pattern{"?m", "movie/cast", "?a"},
+			datalog.Pattern{"?m", "movie/cast", "?b"},
+		),
+		datalog.NewRule(
+			datalog.Pattern{"?a", "person/within2", "?c"},
+			datalog.Pattern{"?a", "person/costar", "?b"},
+			datalog.Pattern{"?b", "person/costar", "?c"},
+		),
+	))
+
+	actual := db.Query(
+		[]string{"?name"},
+		datalog.Pattern{"?danny", "person/name", "Danny Glover"},
+		datalog.Pattern{"?danny", "person/within2", "?other"},
+		datalog.Pattern{"?other", "person/name", "?name"},
+	)
+
+	names := make([]string, len(actual))
+	for i, row := range actual {
+		names[i] = row[0]
+	}
+	// Mel Gibson co-starred with Danny Glover, so his Mad Max co-stars are
+	// two hops away.
+	assert.Contains(t, names, "Mel Gibson")
+	assert.Contains(t, names, "Bruce Spence")
+	assert.NotContains(t, names, "Arnold Schwarzenegger")
+}
+
+func TestRulesRejectUnboundHeadVariable(t *testing.T) {
+	db := datalog.CreateDB(Movies...)
+	err := db.AddRules(datalog.NewRule(
+		datalog.Pattern{"?a", "movie/related", "?z"},
+		datalog.Pattern{"?a", "movie/sequel", "?b"},
+	))
+	assert.Error(t, err)
+	assert.Empty(t, db.Rules())
+}
@@ -0,0 +1,173 @@
+package examples
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/delaneyj/toolbelt/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stores(t *testing.T) map[string]datalog.Store {
+	ctx := context.Background()
+
+	database, err := db.NewDatabase(ctx, db.DatabaseWithFilename(filepath.Join(t.TempDir(), "datalog.sqlite")))
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+
+	sqliteStore, err := datalog.NewSQLiteStore(ctx, database)
+	require.NoError(t, err)
+
+	return map[string]datalog.Store{
+		"memory": datalog.NewMemoryStore(datalog.CreateDB()),
+		"sqlite": sqliteStore,
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.Assert(ctx, typedYears()...))
+			require.NoError(t, store.Assert(ctx,
+				datalog.Triple{"220", "movie/title", "The Abyss"},
+				datalog.Triple{"220", "movie/year", 1989},
+				datalog.Triple{"220", "movie/director", datalog.Ref("100")},
+				datalog.Triple{"220", "movie/released", time.Date(1989, 8, 9, 0, 0, 0, 0, time.UTC)},
+				datalog.Triple{"220", "movie/title", "The Abyss"},
+			))
+
+			directors, err := store.QueryValues(ctx,
+				[]string{"?directorName", "?movieTitle"},
+				datalog.Pattern{"?arnoldId", "person/name", "Arnold Schwarzenegger"},
+				datalog.Pattern{"?movieId", "movie/cast", "?arnoldId"},
+				datalog.Pattern{"?movieId", "movie/title", "?movieTitle"},
+				datalog.Pattern{"?movieId", "movie/director", "?directorId"},
+				datalog.Pattern{"?directorId", "person/name", "?directorName"},
+			)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, [][]datalog.Value{
+				{"James Cameron", "The Terminator"},
+				{"John McTiernan", "Predator"},
+				{"Mark L. Lester", "Commando"},
+				{"James Cameron", "Terminator 2: Judgment Day"},
+				{"Jonathan Mostow", "Terminator 3: Rise of the Machines"},
+			}, directors)
+
+			refs, err := store.QueryValues(ctx,
+				[]string{"?d", "?released"},
+				datalog.Pattern{"?m", "movie/title", "The Abyss"},
+				datalog.Pattern{"?m", "movie/director", "?d"},
+				datalog.Pattern{"?d", "person/name", "James Cameron"},
+				datalog.Pattern{"?m", "movie/released", "?released"},
+			)
+			require.NoError(t, err)
+			require.Len(t, refs, 1)
+			// ?d may be bound from the ref or from the entity id, depending on
+			// the join order, and both are equal.
+			assert.True(t, datalog.Equal(datalog.Ref("100"), refs[0][0]))
+			assert.Equal(t, time.Date(1989, 8, 9, 0, 0, 0, 0, time.UTC), refs[0][1])
+
+			filtered, err := store.QueryValues(ctx,
+				[]string{"?title"},
+				datalog.Pattern{"?m", "movie/year", "?year"},
+				datalog.Gt("?year", 1990),
+				datalog.Pattern{"?m", "movie/title", "?title"},
+				datalog.Pattern{"?arnold", "person/name", "Arnold Schwarzenegger"},
+				datalog.Not(datalog.Pattern{"?m", "movie/cast", "?arnold"}),
+				datalog.Not(
+					datalog.Pattern{"?m", "movie/director", "?d"},
+					datalog.Pattern{"?d", "person/name", "?name"},
+					datalog.HasPrefix("?name", "Mel"),
+				),
+			)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, [][]datalog.Value{{"Lethal Weapon 3"}}, filtered)
+
+			typed, err := store.QueryWhere(ctx, datalog.Pattern{"?m", "movie/year", "1989"})
+			require.NoError(t, err)
+			assert.Empty(t, typed)
+
+			require.NoError(t, store.Retract(ctx, datalog.Triple{"220", "movie/director", "100"}))
+			gone, err := store.QueryWhere(ctx, datalog.Pattern{"220", "movie/director", "?d"})
+			require.NoError(t, err)
+			assert.Empty(t, gone)
+
+			counts, err := store.QueryValues(ctx,
+				[]string{"count(?m)"},
+				datalog.Pattern{"?m", "movie/year", "?year"},
+				datalog.Lt("?year", 1981),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, [][]datalog.Value{{int64(2)}}, counts)
+		})
+	}
+}
+
+func TestStoresKeepTimesOutsideUnixNano(t *testing.T) {
+	ctx := context.Background()
+	times := []time.Time{
+		time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1600, 2, 29, 12, 30, 0, 1, time.UTC),
+		time.Date(2500, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, at := range times {
+				require.NoError(t, store.Assert(ctx, datalog.Triple{strconv.Itoa(i), "event/at", at}))
+			}
+			for i, at := range times {
+				values, err := store.QueryValues(ctx, []string{"?e", "?at"},
+					datalog.Pattern{"?e", "event/at", at},
+					datalog.Pattern{"?e", "event/at", "?at"},
+				)
+				require.NoError(t, err)
+				assert.Equal(t, [][]datalog.Value{{strconv.Itoa(i), at}}, values)
+			}
+		})
+	}
+}
+
+func TestStoresRejectInvalidValues(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			err := store.Assert(ctx,
+				datalog.Triple{"1", "note", "kept out"},
+				datalog.Triple{"2", "note", nil},
+			)
+			require.ErrorIs(t, err, datalog.ErrInvalidValue)
+			states, err := store.QueryWhere(ctx, datalog.Pattern{"?e", "note", "?v"})
+			require.NoError(t, err)
+			assert.Empty(t, states)
+		})
+	}
+}
+
+func TestSQLiteStorePersists(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "datalog.sqlite")
+
+	database, err := db.NewDatabase(ctx, db.DatabaseWithFilename(filename))
+	require.NoError(t, err)
+	store, err := datalog.NewSQLiteStore(ctx, database)
+	require.NoError(t, err)
+	require.NoError(t, store.Assert(ctx, Movies...))
+	require.NoError(t, database.Close())
+
+	database, err = db.NewDatabase(ctx, db.DatabaseWithFilename(filename))
+	require.NoError(t, err)
+	defer database.Close()
+	store, err = datalog.NewSQLiteStore(ctx, database)
+	require.NoError(t, err)
+
+	n, err := store.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(Movies), n)
+}
@@ -0,0 +1,94 @@
+package examples
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt/datalog"
+	"github.com/stretchr/testify/assert"
+)
+
+var typedMovies = []datalog.Triple{
+	{"100", "person/name", "James Cameron"},
+	{"100", "person/born", time.Date(1954, 8, 16, 0, 0, 0, 0, time.UTC)},
+	{"200", "movie/title", "The Terminator"},
+	{"200", "movie/year", 1984},
+	{"200", "movie/rating", 8.1},
+	{"200", "movie/director", datalog.Ref("100")},
+	{"200", "movie/released", true},
+	{"207", "movie/title", "Terminator 2: Judgment Day"},
+	{"207", "movie/year", int64(1991)},
+	{"207", "movie/director", datalog.Ref("100")},
+}
+
+func TestTypedValuesMatchNatively(t *testing.T) {
+	db := datalog.CreateDB(typedMovies...)
+
+	actual := db.QueryValues(
+		[]string{"?title", "?year"},
+		datalog.Pattern{"?m", "movie/year", 1984},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+		datalog.Pattern{"?m", "movie/year", "?year"},
+	)
+	assert.Equal(t, [][]datalog.Value{{"The Terminator", int64(1984)}}, actual)
+
+	assert.Empty(t, db.QueryWhere(datalog.Pattern{"?m", "movie/year", "1984"}))
+}
+
+func TestTypedRefsJoinEntities(t *testing.T) {
+	db := datalog.CreateDB(typedMovies...)
+
+	actual := db.Query(
+		[]string{"?title", "?born"},
+		datalog.Pattern{"?m", "movie/director", "?d"},
+		datalog.Pattern{"?d", "person/name", "James Cameron"},
+		datalog.Pattern{"?d", "person/born", "?born"},
+		datalog.Pattern{"?m", "movie/title", "?title"},
+	)
+	assert.Equal(t, [][]string{
+		{"The Terminator", "1954-08-16T00:00:00Z"},
+		{"Terminator 2: Judgment Day", "1954-08-16T00:00:00Z"},
+	}, actual)
+}
+
+func TestCompareValues(t *testing.T) {
+	assert.Negative(t, datalog.Compare(1984, int64(1991)))
+	assert.Positive(t, datalog.Compare(8.1, 8))
+	assert.Zero(t, datalog.Compare(int32(7), 7.0))
+	assert.Negative(t, datalog.Compare("Aliens", "Predator"))
+	assert.Zero(t, datalog.Compare(datalog.Ref("100"), "100"))
+	assert.Negative(t, datalog.Compare(
+		time.Date(1984, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1991, 1, 1, 0, 0, 0, 0, time.FixedZone("EST", -5*3600)),
+	))
+	assert.Negative(t, datalog.Compare(false, true))
+	assert.Negative(t, datalog.Compare(1, "1"))
+
+	assert.True(t, datalog.Equal(
+		time.Date(1984, 1, 1, 5, 0, 0, 0, time.UTC),
+		time.Date(1984, 1, 1, 0, 0, 0, 0, time.FixedZone("EST", -5*3600)),
+	))
+	assert.Equal(t, datalog.KindRef, datalog.KindOf(datalog.Ref("100")))
+	assert.Equal(t, datalog.KindInt, datalog.KindOf(uint16(3)))
+}
+
+func TestInvalidValuesAreRejected(t *testing.T) {
+	huge := uint64(math.MaxInt64) + 1
+
+	assert.PanicsWithError(t, "datalog: invalid value: 9223372036854775808 overflows int64", func() {
+		datalog.CreateDB(datalog.Triple{"1", "counter", huge})
+	})
+	assert.Panics(t, func() {
+		datalog.CreateDB().Assert(datalog.Triple{"1", "note", nil})
+	})
+
+	db := datalog.CreateDB(datalog.Triple{"1", "counter", uint64(math.MaxInt64)})
+	_, err := db.AssertBatchAt(time.Now(), datalog.Triple{"2", "counter", huge})
+	assert.ErrorIs(t, err, datalog.ErrInvalidValue)
+	assert.Equal(t, 1, db.Len())
+
+	// An overflowing constant in a query matches nothing rather than wrapping.
+	assert.Empty(t, db.QueryWhere(datalog.Pattern{"?e", "counter", uint64(math.MaxUint64)}))
+	assert.Positive(t, datalog.Compare(huge, int64(1)))
+}
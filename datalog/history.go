@@ -0,0 +1,146 @@
+package datalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+var (
+	ErrNoHistory    = errors.New("datalog: database does not record history, see CreateDBWithHistory")
+	ErrTxOutOfOrder = errors.New("datalog: transaction time is before the last recorded change")
+)
+
+// Datom is one change recorded in the history of a DB: a triple that was
+// asserted or retracted at transaction time Tx.
+type Datom struct {
+	Triple Triple
+	Tx     time.Time
+	// Added is true for an assert and false for a retract.
+	Added bool
+}
+
+// record adds a change to the end of the history. Transaction times never go
+// backwards, see checkTx. It does nothing unless history is enabled.
+func (db *DB) record(datom Datom) {
+	if !db.history {
+		return
+	}
+	db.log = append(db.log, datom)
+	db.lastTx = datom.Tx
+}
+
+// checkTx rejects a transaction time before the last recorded change, so the
+// history replays to the stored triples.
+func (db *DB) checkTx(tx time.Time) error {
+	if tx.Before(db.lastTx) {
+		return fmt.Errorf("%w: %s is before %s", ErrTxOutOfOrder, tx.Format(time.RFC3339Nano), db.lastTx.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// now returns the transaction time for a change made without one, which does
+// not go backwards even if the clock does.
+func (db *DB) now() time.Time {
+	if now := time.Now(); now.After(db.lastTx) {
+		return now
+	}
+	return db.lastTx
+}
+
+// AsOf returns a snapshot of the database as it was at t, built by replaying
+// every recorded change with a transaction time at or before t. Rules are
+// carried over and evaluated against the snapshot. The snapshot is
+// independent of db, so later changes to either one do not affect the other.
+// A DB created without history returns ErrNoHistory.
+func (db *DB) AsOf(t time.Time) (*DB, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if !db.history {
+		return nil, ErrNoHistory
+	}
+
+	var (
+		log  []Datom
+		last = map[Triple]int{}
+	)
+	for _, datom := range db.log {
+		if datom.Tx.After(t) {
+			continue
+		}
+		last[tripleKey(datom.Triple)] = len(log)
+		log = append(log, datom)
+	}
+
+	// Keep the surviving triples in the order they were last asserted, which
+	// is the order a DB built by the same changes would store them in.
+	positions := make([]int, 0, len(last))
+	for _, i := range last {
+		if log[i].Added {
+			positions = append(positions, i)
+		}
+	}
+	slices.Sort(positions)
+	triples := make([]Triple, len(positions))
+	for i, position := range positions {
+		triples[i] = log[position].Triple
+	}
+
+	snapshot := newDB(triples)
+	snapshot.history = true
+	snapshot.log = log
+	if len(log) > 0 {
+		snapshot.lastTx = log[len(log)-1].Tx
+	}
+	snapshot.rules = slices.Clone(db.rules)
+	snapshot.strata = db.strata
+	if len(snapshot.rules) > 0 {
+		snapshot.evaluateRules()
+	}
+	return snapshot, nil
+}
+
+// History returns every recorded assert and retract of triples about entity,
+// ordered by transaction time.
+func (db *DB) History(entity Value) []Datom {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	key := valueKey(entity)
+	var datoms []Datom
+	for _, datom := range db.log {
+		if valueKey(datom.Triple[0]) == key {
+			datoms = append(datoms, datom)
+		}
+	}
+	return datoms
+}
+
+// CompactHistory folds the changes recorded at or before t into the asserts
+// of the triples still stored at t, and reports how many changes were
+// dropped. Snapshots with AsOf at or after t are unchanged, earlier ones lose
+// the retracted and replaced triples.
+func (db *DB) CompactHistory(t time.Time) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	cut := sort.Search(len(db.log), func(i int) bool {
+		return db.log[i].Tx.After(t)
+	})
+	last := map[Triple]int{}
+	for i, datom := range db.log[:cut] {
+		last[tripleKey(datom.Triple)] = i
+	}
+
+	kept := make([]Datom, 0, len(db.log)-cut+len(last))
+	for i, datom := range db.log[:cut] {
+		if datom.Added && last[tripleKey(datom.Triple)] == i {
+			kept = append(kept, datom)
+		}
+	}
+	dropped := cut - len(kept)
+	db.log = append(kept, db.log[cut:]...)
+	return dropped
+}
@@ -0,0 +1,572 @@
+package datalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Query is a parsed text query, ready to run with DB.QueryValues or a Store.
+type Query struct {
+	Find  []string
+	Where []Clause
+}
+
+// SyntaxError reports a malformed text query. Line and Column are 1-based,
+// Offset is the byte offset into the source.
+type SyntaxError struct {
+	Offset int
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("datalog: %d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseQuery parses a query written in an EDN style syntax:
+//
+//	[:find ?title (count ?actor)
+//	 :where [?m :movie/title ?title]
+//	        [?m :movie/year ?year]
+//	        [(< ?year 1990)]
+//	        [?m :movie/cast ?actor]
+//	        (not [?m :movie/sequel ?s])]
+//
+// Values may be variables, strings, integers, floats, true and false. A
+// keyword such as :movie/title is the string "movie/title", #ref "100" is a
+// Ref and #inst "1985-06-07T00:00:00Z" is an RFC 3339 time. Only unquoted
+// symbols such as ?m are variables, so "?m" is a Literal. Commas are
+// whitespace and ; starts a comment that runs to the end of the line. The
+// outer brackets are optional.
+func ParseQuery(src string) (Query, error) {
+	p := &parser{lexer: lexer{src: src}}
+	return p.query()
+}
+
+// QueryString parses src with ParseQuery and runs it with QueryValues.
+func (db *DB) QueryString(src string) ([][]Value, error) {
+	q, err := ParseQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryValues(q.Find, q.Where...), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenOpenBracket
+	tokenCloseBracket
+	tokenOpenParen
+	tokenCloseParen
+	tokenString
+	tokenNumber
+	tokenKeyword
+	tokenSymbol
+	tokenTag
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of query"
+	case tokenOpenBracket:
+		return `"["`
+	case tokenCloseBracket:
+		return `"]"`
+	case tokenOpenParen:
+		return `"("`
+	case tokenCloseParen:
+		return `")"`
+	case tokenString:
+		return "string"
+	case tokenNumber:
+		return "number"
+	case tokenKeyword:
+		return "keyword"
+	case tokenTag:
+		return "tag"
+	default:
+		return "symbol"
+	}
+}
+
+type token struct {
+	kind tokenKind
+	// text is the raw token, or the unquoted contents of a string.
+	text   string
+	offset int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF, tokenOpenBracket, tokenCloseBracket, tokenOpenParen, tokenCloseParen:
+		return t.kind.String()
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return t.kind.String() + " " + t.text
+	}
+}
+
+type lexer struct {
+	src    string
+	offset int
+}
+
+func (l *lexer) errorf(offset int, format string, args ...any) *SyntaxError {
+	line := 1 + strings.Count(l.src[:offset], "\n")
+	lineStart := strings.LastIndexByte(l.src[:offset], '\n') + 1
+	return &SyntaxError{
+		Offset: offset,
+		Line:   line,
+		Column: 1 + utf8.RuneCountInString(l.src[lineStart:offset]),
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func isDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(`[](){}",;`, r)
+}
+
+func (l *lexer) next() (token, error) {
+	for l.offset < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.offset:])
+		switch {
+		case r == ';':
+			if end := strings.IndexByte(l.src[l.offset:], '\n'); end >= 0 {
+				l.offset += end
+			} else {
+				l.offset = len(l.src)
+			}
+		case r == ',' || unicode.IsSpace(r):
+			l.offset += size
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokenEOF, offset: l.offset}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	start := l.offset
+	switch l.src[start] {
+	case '[':
+		l.offset++
+		return token{kind: tokenOpenBracket, text: "[", offset: start}, nil
+	case ']':
+		l.offset++
+		return token{kind: tokenCloseBracket, text: "]", offset: start}, nil
+	case '(':
+		l.offset++
+		return token{kind: tokenOpenParen, text: "(", offset: start}, nil
+	case ')':
+		l.offset++
+		return token{kind: tokenCloseParen, text: ")", offset: start}, nil
+	case '{', '}':
+		return token{}, l.errorf(start, "maps are not supported")
+	case '"':
+		return l.string()
+	}
+
+	for l.offset < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.offset:])
+		if isDelimiter(r) {
+			break
+		}
+		l.offset += size
+	}
+	text := l.src[start:l.offset]
+
+	kind := tokenSymbol
+	switch {
+	case text[0] == ':':
+		kind = tokenKeyword
+	case text[0] == '#':
+		kind = tokenTag
+	case text[0] >= '0' && text[0] <= '9',
+		len(text) > 1 && (text[0] == '-' || text[0] == '+') && text[1] >= '0' && text[1] <= '9':
+		kind = tokenNumber
+	}
+	if (kind == tokenKeyword || kind == tokenTag) && len(text) == 1 {
+		return token{}, l.errorf(start, "expected a name after %q", text)
+	}
+	return token{kind: kind, text: text, offset: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.offset
+	var sb strings.Builder
+	l.offset++
+	for l.offset < len(l.src) {
+		c := l.src[l.offset]
+		switch c {
+		case '"':
+			l.offset++
+			return token{kind: tokenString, text: sb.String(), offset: start}, nil
+		case '\\':
+			if l.offset+1 >= len(l.src) {
+				return token{}, l.errorf(start, "unterminated string")
+			}
+			switch escaped := l.src[l.offset+1]; escaped {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '"', '\\':
+				sb.WriteByte(escaped)
+			default:
+				return token{}, l.errorf(l.offset, "unknown escape \\%c", escaped)
+			}
+			l.offset += 2
+		default:
+			sb.WriteByte(c)
+			l.offset++
+		}
+	}
+	return token{}, l.errorf(start, "unterminated string")
+}
+
+type parser struct {
+	lexer  lexer
+	peeked *token
+}
+
+func (p *parser) peek() (token, error) {
+	if p.peeked == nil {
+		t, err := p.lexer.next()
+		if err != nil {
+			return token{}, err
+		}
+		p.peeked = &t
+	}
+	return *p.peeked, nil
+}
+
+func (p *parser) next() (token, error) {
+	t, err := p.peek()
+	p.peeked = nil
+	return t, err
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t, err := p.next()
+	if err != nil {
+		return token{}, err
+	}
+	if t.kind != kind {
+		return token{}, p.unexpected(t, what)
+	}
+	return t, nil
+}
+
+func (p *parser) unexpected(t token, what string) *SyntaxError {
+	return p.lexer.errorf(t.offset, "expected %s, found %s", what, t.describe())
+}
+
+func (p *parser) query() (Query, error) {
+	t, err := p.peek()
+	if err != nil {
+		return Query{}, err
+	}
+	bracketed := t.kind == tokenOpenBracket
+	if bracketed {
+		p.next()
+	}
+
+	if t, err = p.next(); err != nil {
+		return Query{}, err
+	}
+	if t.kind != tokenKeyword || t.text != ":find" {
+		return Query{}, p.unexpected(t, ":find")
+	}
+
+	var (
+		q          Query
+		findTokens []token
+	)
+	for {
+		if t, err = p.peek(); err != nil {
+			return Query{}, err
+		}
+		if t.kind == tokenKeyword && t.text == ":where" {
+			break
+		}
+		find, err := p.findElement()
+		if err != nil {
+			return Query{}, err
+		}
+		q.Find = append(q.Find, find)
+		findTokens = append(findTokens, t)
+	}
+	if len(q.Find) == 0 {
+		return Query{}, p.lexer.errorf(t.offset, "expected at least one find element")
+	}
+	p.next()
+
+	for {
+		if t, err = p.peek(); err != nil {
+			return Query{}, err
+		}
+		if t.kind == tokenEOF || bracketed && t.kind == tokenCloseBracket {
+			break
+		}
+		clause, err := p.clause()
+		if err != nil {
+			return Query{}, err
+		}
+		q.Where = append(q.Where, clause)
+	}
+	if len(q.Where) == 0 {
+		return Query{}, p.lexer.errorf(t.offset, "expected at least one where clause")
+	}
+	if bracketed {
+		if _, err := p.expect(tokenCloseBracket, `"]" to close the query`); err != nil {
+			return Query{}, err
+		}
+	}
+	if t, err = p.next(); err != nil {
+		return Query{}, err
+	}
+	if t.kind != tokenEOF {
+		return Query{}, p.unexpected(t, "end of query")
+	}
+
+	// Variables that only appear inside a negation are never bound in the
+	// results, so finding them is almost certainly a mistake.
+	bound := map[string]bool{}
+	for _, clause := range q.Where {
+		if _, ok := clause.(NotClause); ok {
+			continue
+		}
+		for _, v := range clause.variables() {
+			bound[v] = true
+		}
+	}
+	for i, find := range q.Find {
+		if v := parseFindSpec(find).variable; !bound[v] {
+			return Query{}, p.lexer.errorf(findTokens[i].offset, "variable %s is not bound by any where clause", v)
+		}
+	}
+	return q, nil
+}
+
+// findElement parses ?x or an aggregate such as (count ?x).
+func (p *parser) findElement() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case t.kind == tokenSymbol && isVariable(t.text):
+		return t.text, nil
+	case t.kind == tokenOpenParen:
+		name, err := p.expect(tokenSymbol, "aggregate function")
+		if err != nil {
+			return "", err
+		}
+		switch name.text {
+		case AggregateCount, AggregateSum, AggregateMin, AggregateMax, AggregateDistinct:
+		default:
+			return "", p.lexer.errorf(name.offset, "unknown aggregate %s", name.text)
+		}
+		v, err := p.variable()
+		if err != nil {
+			return "", err
+		}
+		if _, err := p.expect(tokenCloseParen, `")"`); err != nil {
+			return "", err
+		}
+		return name.text + "(" + v + ")", nil
+	default:
+		return "", p.unexpected(t, "variable, aggregate or :where")
+	}
+}
+
+func (p *parser) variable() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != tokenSymbol || !isVariable(t.text) {
+		return "", p.unexpected(t, "variable")
+	}
+	return t.text, nil
+}
+
+// clause parses [e a v], [(op arg arg)] or (not clause...).
+func (p *parser) clause() (Clause, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tokenOpenBracket:
+		next, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if next.kind == tokenOpenParen {
+			return p.predicate()
+		}
+		return p.pattern(t)
+	case tokenOpenParen:
+		return p.not()
+	default:
+		return nil, p.unexpected(t, "clause")
+	}
+}
+
+func (p *parser) pattern(open token) (Clause, error) {
+	var pattern Pattern
+	for i := range pattern {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokenCloseBracket {
+			return nil, p.lexer.errorf(open.offset, "pattern has %d elements, want 3", i)
+		}
+		if pattern[i], err = p.value(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect(tokenCloseBracket, `"]" after 3 pattern elements`); err != nil {
+		return nil, err
+	}
+	return pattern, nil
+}
+
+func (p *parser) predicate() (Clause, error) {
+	p.next() // (
+	op, err := p.expect(tokenSymbol, "predicate operator")
+	if err != nil {
+		return nil, err
+	}
+	var args []Value
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokenCloseParen {
+			break
+		}
+		arg, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next() // )
+	if _, err := p.expect(tokenCloseBracket, `"]"`); err != nil {
+		return nil, err
+	}
+
+	predicate, err := NewPredicate(op.text, args...)
+	if err != nil {
+		return nil, p.lexer.errorf(op.offset, "%s", strings.TrimPrefix(err.Error(), "datalog: "))
+	}
+	return predicate, nil
+}
+
+func (p *parser) not() (Clause, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenSymbol || t.text != "not" {
+		return nil, p.unexpected(t, "not")
+	}
+	var clauses []Clause
+	for {
+		if t, err = p.peek(); err != nil {
+			return nil, err
+		}
+		if t.kind == tokenCloseParen {
+			break
+		}
+		clause, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(clauses) == 0 {
+		return nil, p.lexer.errorf(t.offset, "not needs at least one clause")
+	}
+	p.next()
+	return Not(clauses...), nil
+}
+
+// value parses a variable or constant.
+func (p *parser) value() (Value, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tokenString:
+		return constant(t.text), nil
+	case tokenKeyword:
+		return constant(t.text[1:]), nil
+	case tokenNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.lexer.errorf(t.offset, "invalid number %s", t.text)
+		}
+		return f, nil
+	case tokenSymbol:
+		switch {
+		case isVariable(t.text):
+			return t.text, nil
+		case t.text == "true":
+			return true, nil
+		case t.text == "false":
+			return false, nil
+		}
+		return nil, p.lexer.errorf(t.offset, "unknown symbol %s", t.text)
+	case tokenTag:
+		return p.tagged(t)
+	default:
+		return nil, p.unexpected(t, "value")
+	}
+}
+
+// constant keeps a quoted string or keyword from being read as a variable.
+func constant(s string) Value {
+	if isVariable(s) {
+		return Literal(s)
+	}
+	return s
+}
+
+func (p *parser) tagged(tag token) (Value, error) {
+	arg, err := p.expect(tokenString, "string after "+tag.text)
+	if err != nil {
+		return nil, err
+	}
+	switch tag.text {
+	case "#ref":
+		return Ref(arg.text), nil
+	case "#inst":
+		t, err := time.Parse(time.RFC3339Nano, arg.text)
+		if err != nil {
+			return nil, p.lexer.errorf(arg.offset, "invalid #inst %q, want RFC 3339", arg.text)
+		}
+		return t, nil
+	default:
+		return nil, p.lexer.errorf(tag.offset, "unknown tag %s", tag.text)
+	}
+}
@@ -0,0 +1,290 @@
+package datalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndexStats describes the cardinality of one index.
+type IndexStats struct {
+	// Keys is the number of distinct values in the indexed position.
+	Keys int
+}
+
+// AverageBucket is the expected number of triples for a key that is only
+// known at query time.
+func (s IndexStats) AverageBucket(triples int) float64 {
+	if s.Keys == 0 {
+		return 0
+	}
+	return float64(triples) / float64(s.Keys)
+}
+
+// Stats holds the cardinality statistics the planner uses to order clauses.
+// Derived triples are included.
+type Stats struct {
+	Triples   int
+	Entity    IndexStats
+	Attribute IndexStats
+	Value     IndexStats
+}
+
+// Stats reports cardinality statistics for each index.
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.stats()
+}
+
+func (db *DB) stats() Stats {
+	stats := Stats{
+		Triples:   len(db.triples),
+		Entity:    IndexStats{Keys: len(db.entityIndex)},
+		Attribute: IndexStats{Keys: len(db.attrIndex)},
+		Value:     IndexStats{Keys: len(db.valueIndex)},
+	}
+	if db.derived != nil {
+		derived := db.derived.stats()
+		stats.Triples += derived.Triples
+		// Keys shared by base and derived triples are counted twice, which
+		// only makes the estimates slightly optimistic.
+		stats.Entity.Keys += derived.Entity.Keys
+		stats.Attribute.Keys += derived.Attribute.Keys
+		stats.Value.Keys += derived.Value.Keys
+	}
+	return stats
+}
+
+func (s Stats) position(i int) IndexStats {
+	switch i {
+	case 0:
+		return s.Entity
+	case 1:
+		return s.Attribute
+	default:
+		return s.Value
+	}
+}
+
+var indexNames = [3]string{"entity", "attribute", "value"}
+
+// bucketLen counts the triples, including derived ones, stored under key in
+// the index for position.
+func (db *DB) bucketLen(position int, key Value) int {
+	n := len(db.index(position)[valueKey(key)])
+	if db.derived != nil {
+		n += db.derived.bucketLen(position, key)
+	}
+	return n
+}
+
+// PlanStep is one clause in the order chosen by the planner.
+type PlanStep struct {
+	Clause Clause
+	// Position is the index of the clause in the query as written.
+	Position int
+	// Access names the index used to find candidates for a pattern ("entity",
+	// "attribute", "value" or "scan"), or "filter" and "not" for other
+	// clauses.
+	Access string
+	// Estimate is the expected number of candidate triples per input state.
+	Estimate float64
+}
+
+// Plan is the order in which the clauses of a query are evaluated.
+type Plan []PlanStep
+
+// Clauses returns the clauses in evaluation order.
+func (p Plan) Clauses() []Clause {
+	clauses := make([]Clause, len(p))
+	for i, step := range p {
+		clauses[i] = step.Clause
+	}
+	return clauses
+}
+
+func (p Plan) String() string {
+	var sb strings.Builder
+	for i, step := range p {
+		fmt.Fprintf(&sb, "%d. %s via %s", i+1, formatClause(step.Clause), step.Access)
+		if _, ok := step.Clause.(Pattern); ok {
+			fmt.Fprintf(&sb, " (~%.1f)", step.Estimate)
+		}
+		fmt.Fprintf(&sb, " [clause %d]\n", step.Position+1)
+	}
+	return sb.String()
+}
+
+func formatClause(clause Clause) string {
+	switch clause := clause.(type) {
+	case Pattern:
+		parts := make([]string, len(clause))
+		for i, part := range clause {
+			parts[i] = formatPart(part)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case Predicate:
+		parts := make([]string, len(clause.Args))
+		for i, arg := range clause.Args {
+			parts[i] = formatPart(arg)
+		}
+		return "[(" + clause.Op + " " + strings.Join(parts, " ") + ")]"
+	case NotClause:
+		parts := make([]string, len(clause.Clauses))
+		for i, inner := range clause.Clauses {
+			parts[i] = formatClause(inner)
+		}
+		return "(not " + strings.Join(parts, " ") + ")"
+	default:
+		return fmt.Sprint(clause)
+	}
+}
+
+func formatPart(v Value) string {
+	switch s := v.(type) {
+	case string:
+		if !isVariable(s) {
+			return fmt.Sprintf("%q", s)
+		}
+	case Literal:
+		return fmt.Sprintf("%q", string(s))
+	}
+	return FormatValue(v)
+}
+
+// Explain returns the plan QueryWhere would use for the clauses.
+func (db *DB) Explain(where ...Clause) Plan {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.plan(where, nil)
+}
+
+// patternCost estimates the candidates for the pattern at position i given
+// the bound variables. It reports false to fall back to the index estimate.
+type patternCost func(i int, pattern Pattern, bound map[string]bool) (float64, string, bool)
+
+// plan orders clauses greedily. Predicates and negations run as soon as the
+// variables they share with the rest of the query are bound; otherwise the
+// pattern with the fewest expected candidates goes next, keeping the written
+// order on ties.
+func (db *DB) plan(clauses []Clause, cost patternCost) Plan {
+	stats := db.stats()
+
+	// Count how many clauses mention each variable, so a negation only waits
+	// for the variables it shares.
+	mentions := map[string]int{}
+	for _, clause := range clauses {
+		for _, v := range uniqueVariables(clause) {
+			mentions[v]++
+		}
+	}
+
+	bound := map[string]bool{}
+	ready := func(clause Clause) bool {
+		for _, v := range uniqueVariables(clause) {
+			if _, isNot := clause.(NotClause); isNot && mentions[v] < 2 {
+				continue
+			}
+			if !bound[v] {
+				return false
+			}
+		}
+		return true
+	}
+
+	plan := make(Plan, 0, len(clauses))
+	done := make([]bool, len(clauses))
+	for len(plan) < len(clauses) {
+		next := -1
+		var step PlanStep
+
+		for i, clause := range clauses {
+			if _, ok := clause.(Pattern); ok || done[i] || !ready(clause) {
+				continue
+			}
+			next, step = i, filterStep(i, clause)
+			break
+		}
+
+		if next < 0 {
+			for i, clause := range clauses {
+				pattern, ok := clause.(Pattern)
+				if done[i] || !ok {
+					continue
+				}
+				estimate, access, ok := 0.0, "", false
+				if cost != nil {
+					estimate, access, ok = cost(i, pattern, bound)
+				}
+				if !ok {
+					estimate, access = db.estimate(stats, pattern, bound)
+				}
+				if next < 0 || estimate < step.Estimate {
+					next, step = i, PlanStep{Clause: clause, Position: i, Access: access, Estimate: estimate}
+				}
+			}
+		}
+
+		if next < 0 {
+			// Only filters whose variables are never bound remain; they run
+			// last in the order written.
+			for i, clause := range clauses {
+				if !done[i] {
+					next, step = i, filterStep(i, clause)
+					break
+				}
+			}
+		}
+
+		done[next] = true
+		plan = append(plan, step)
+		if pattern, ok := step.Clause.(Pattern); ok {
+			for _, v := range pattern.variables() {
+				bound[v] = true
+			}
+		}
+	}
+	return plan
+}
+
+func filterStep(i int, clause Clause) PlanStep {
+	access := "filter"
+	if _, ok := clause.(NotClause); ok {
+		access = "not"
+	}
+	return PlanStep{Clause: prepare(clause), Position: i, Access: access}
+}
+
+// estimate predicts how many triples will be read for pattern. Constants use
+// the exact bucket size, variables bound by earlier clauses use the average
+// bucket size of their index.
+func (db *DB) estimate(stats Stats, pattern Pattern, bound map[string]bool) (float64, string) {
+	best, access := float64(stats.Triples), "scan"
+	for i, part := range pattern {
+		var estimate float64
+		switch {
+		case !isVariable(part):
+			estimate = float64(db.bucketLen(i, part))
+		case bound[part.(string)]:
+			estimate = stats.position(i).AverageBucket(stats.Triples)
+		default:
+			continue
+		}
+		if estimate < best || access == "scan" {
+			best, access = estimate, indexNames[i]
+		}
+	}
+	return best, access
+}
+
+func uniqueVariables(clause Clause) []string {
+	seen := map[string]bool{}
+	var vars []string
+	for _, v := range clause.variables() {
+		if !seen[v] {
+			seen[v] = true
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
@@ -0,0 +1,256 @@
+package datalog
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// Rule derives a triple shaped like Head for every state that satisfies all of
+// the Body clauses. Every variable in the head and in predicates must be bound
+// by a pattern in the body.
+type Rule struct {
+	Head Pattern
+	Body []Clause
+}
+
+func NewRule(head Pattern, body ...Clause) Rule {
+	return Rule{Head: head, Body: body}
+}
+
+var (
+	ErrRuleWithoutBody = errors.New("datalog: rule has no body patterns")
+	ErrNotStratifiable = errors.New("datalog: rules negate a relation that depends on itself")
+)
+
+func (r Rule) validate() error {
+	bound := map[string]bool{}
+	patterns := 0
+	for _, clause := range r.Body {
+		if pattern, ok := clause.(Pattern); ok {
+			patterns++
+			for _, v := range pattern.variables() {
+				bound[v] = true
+			}
+		}
+	}
+	if patterns == 0 {
+		return ErrRuleWithoutBody
+	}
+
+	for _, v := range r.Head.variables() {
+		if !bound[v] {
+			return fmt.Errorf("datalog: head variable %s is not bound in the rule body", v)
+		}
+	}
+	for _, clause := range r.Body {
+		// Variables that only appear inside a Not are local to it.
+		if _, ok := clause.(Predicate); !ok {
+			continue
+		}
+		for _, v := range clause.variables() {
+			if !bound[v] {
+				return fmt.Errorf("datalog: variable %s is not bound by a pattern in the rule body", v)
+			}
+		}
+	}
+	return nil
+}
+
+// AddRules registers rules and evaluates them to a fixpoint, so Query and
+// QueryWhere return derived triples next to the base triples. Rules may refer
+// to their own heads, which allows transitive relations such as ancestors or
+// reachability. Negation must be stratified: a rule may only negate
+// attributes that do not depend on its own head, otherwise
+// ErrNotStratifiable is returned.
+func (db *DB) AddRules(rules ...Rule) error {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	all := append(append([]Rule(nil), db.rules...), rules...)
+	strata, err := stratify(all)
+	if err != nil {
+		return err
+	}
+
+	db.rules = all
+	db.strata = strata
+	db.evaluateRules()
+	return nil
+}
+
+// Rules returns the rules registered with the database.
+func (db *DB) Rules() []Rule {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]Rule(nil), db.rules...)
+}
+
+type ruleDependency struct {
+	rule    int
+	negated bool
+}
+
+// dependencies lists the rules whose heads may produce triples read by the
+// clauses, treating variable attributes as reading or producing anything.
+func dependencies(rules []Rule, clauses []Clause, negated bool) (deps []ruleDependency) {
+	for _, clause := range clauses {
+		switch clause := clause.(type) {
+		case Pattern:
+			for j, other := range rules {
+				attr, head := clause[1], other.Head[1]
+				if isVariable(attr) || isVariable(head) || Equal(attr, head) {
+					deps = append(deps, ruleDependency{rule: j, negated: negated})
+				}
+			}
+		case NotClause:
+			deps = append(deps, dependencies(rules, clause.Clauses, true)...)
+		}
+	}
+	return deps
+}
+
+// stratify groups rules so that every rule is evaluated after the rules it
+// negates have reached their fixpoint.
+func stratify(rules []Rule) ([][]Rule, error) {
+	deps := make([][]ruleDependency, len(rules))
+	for i, rule := range rules {
+		deps[i] = dependencies(rules, rule.Body, false)
+	}
+
+	levels := make([]int, len(rules))
+	for changed := true; changed; {
+		changed = false
+		for i := range rules {
+			for _, dep := range deps[i] {
+				need := levels[dep.rule]
+				if dep.negated {
+					need++
+				}
+				if levels[i] < need {
+					if need >= len(rules) {
+						return nil, ErrNotStratifiable
+					}
+					levels[i] = need
+					changed = true
+				}
+			}
+		}
+	}
+
+	var strata [][]Rule
+	for i, rule := range rules {
+		for len(strata) <= levels[i] {
+			strata = append(strata, nil)
+		}
+		strata[levels[i]] = append(strata[levels[i]], rule)
+	}
+	return strata, nil
+}
+
+// hasNegation reports whether any rule uses Not, in which case new triples
+// can invalidate earlier conclusions.
+func (db *DB) hasNegation() bool {
+	for _, rule := range db.rules {
+		for _, clause := range rule.Body {
+			if _, ok := clause.(NotClause); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type tripleSource func(pattern Pattern) iter.Seq[Triple]
+
+// evaluateRules recomputes every derived triple from scratch, one stratum at
+// a time. The first round evaluates each rule against all known triples,
+// after which propagate takes over with semi-naive iteration.
+func (db *DB) evaluateRules() {
+	db.derived = nil
+	if len(db.rules) == 0 {
+		return
+	}
+	db.derived = newDB(nil)
+
+	full := func(int) tripleSource { return db.allTriples }
+	for _, rules := range db.strata {
+		var delta []Triple
+		for _, rule := range rules {
+			body := db.plan(rule.Body, nil).Clauses()
+			delta = db.fire(delta, rule, solveFrom(db, []State{{}}, body, full))
+		}
+		db.propagate(rules, delta)
+	}
+}
+
+// propagate derives everything that follows from the delta triples, which
+// must already be stored. Each round only re-evaluates a rule with one body
+// pattern restricted to the triples discovered in the previous round, so
+// facts are not re-derived from inputs that have not changed.
+func (db *DB) propagate(rules []Rule, delta []Triple) {
+	if db.derived == nil {
+		return
+	}
+
+	for len(delta) > 0 {
+		deltaDB := newDB(delta)
+		restricted := func(pattern Pattern) iter.Seq[Triple] {
+			return indexedTriples(deltaDB, pattern)
+		}
+
+		var next []Triple
+		for _, rule := range rules {
+			for i, clause := range rule.Body {
+				if _, ok := clause.(Pattern); !ok {
+					continue
+				}
+				plan := db.plan(rule.Body, func(j int, _ Pattern, _ map[string]bool) (float64, string, bool) {
+					return float64(len(delta)), "delta", j == i
+				})
+				next = db.fire(next, rule, solveFrom(db, []State{{}}, plan.Clauses(), func(j int) tripleSource {
+					if plan[j].Position == i {
+						return restricted
+					}
+					return db.allTriples
+				}))
+			}
+		}
+		delta = next
+	}
+}
+
+// fire instantiates the rule head for each state, stores the triples that are
+// not already known and appends them to discovered.
+func (db *DB) fire(discovered []Triple, rule Rule, states []State) []Triple {
+	for _, state := range states {
+		triple := Triple(bindPattern(rule.Head, state))
+		if db.has(triple) || db.derived.has(triple) {
+			continue
+		}
+		db.derived.insert(triple)
+		discovered = append(discovered, triple)
+	}
+	return discovered
+}
+
+// bindPattern substitutes variables already bound in state so that the most
+// specific index can be used to find candidate triples.
+func bindPattern(pattern Pattern, state State) Pattern {
+	bound := pattern
+	for i, part := range pattern {
+		if !isVariable(part) {
+			continue
+		}
+		if value, ok := state[part.(string)]; ok {
+			bound[i] = value
+		}
+	}
+	return bound
+}
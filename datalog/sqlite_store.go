@@ -0,0 +1,404 @@
+package datalog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/delaneyj/toolbelt/db"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// sqliteStoreSchema keeps every fact once in an EAV ordered table with AEV and
+// VAE covering indexes, mirroring the entity, attribute and value indexes of
+// the in-memory DB. Each position stores the value with its kind so that 1984
+// and "1984" stay distinct; refs are stored as strings and flagged in refs.
+// The columns are declared BLOB so SQLite never converts the stored values.
+const sqliteStoreSchema = `
+CREATE TABLE IF NOT EXISTS datalog_facts (
+	e BLOB NOT NULL,
+	ek INTEGER NOT NULL,
+	a BLOB NOT NULL,
+	ak INTEGER NOT NULL,
+	v BLOB NOT NULL,
+	vk INTEGER NOT NULL,
+	refs INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (e, ek, a, ak, v, vk)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS datalog_facts_aev ON datalog_facts (a, ak, e, ek, v, vk);
+CREATE INDEX IF NOT EXISTS datalog_facts_vae ON datalog_facts (v, vk, a, ak, e, ek);
+`
+
+var sqliteColumns = [3]string{"e", "a", "v"}
+
+// SQLiteStore persists triples in a db.Database and compiles the patterns of
+// a query into a single SQL join. Negations made only of patterns become NOT
+// EXISTS subqueries; predicates and other negations are applied to the
+// joined states in Go.
+type SQLiteStore struct {
+	db *db.Database
+}
+
+// NewSQLiteStore creates the fact tables in database if needed.
+func NewSQLiteStore(ctx context.Context, database *db.Database) (*SQLiteStore, error) {
+	if err := database.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.ExecuteScript(tx, sqliteStoreSchema, nil)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create datalog tables: %w", err)
+	}
+	return &SQLiteStore{db: database}, nil
+}
+
+// Database returns the underlying database.
+func (s *SQLiteStore) Database() *db.Database {
+	return s.db
+}
+
+func (s *SQLiteStore) Assert(ctx context.Context, triples ...Triple) error {
+	return s.db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		for _, triple := range triples {
+			if err := checkTriple(triple); err != nil {
+				return fmt.Errorf("failed to assert %v: %w", triple, err)
+			}
+			args, refs := encodeSQLiteTriple(triple)
+			if err := sqlitex.Execute(tx, `
+				INSERT INTO datalog_facts (e, ek, a, ak, v, vk, refs)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT DO NOTHING`,
+				&sqlitex.ExecOptions{Args: append(args, refs)},
+			); err != nil {
+				return fmt.Errorf("failed to assert %v: %w", triple, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) Retract(ctx context.Context, triples ...Triple) error {
+	return s.db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		for _, triple := range triples {
+			args, _ := encodeSQLiteTriple(triple)
+			if err := sqlitex.Execute(tx, `
+				DELETE FROM datalog_facts
+				WHERE e = ? AND ek = ? AND a = ? AND ak = ? AND v = ? AND vk = ?`,
+				&sqlitex.ExecOptions{Args: args},
+			); err != nil {
+				return fmt.Errorf("failed to retract %v: %w", triple, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) QueryWhere(ctx context.Context, where ...Clause) (states []State, err error) {
+	err = s.db.ReadTX(ctx, func(tx *sqlite.Conn) error {
+		states, err = queryWhereSQLite(tx, where)
+		return err
+	})
+	return states, err
+}
+
+func (s *SQLiteStore) QueryValues(ctx context.Context, find []string, where ...Clause) ([][]Value, error) {
+	states, err := s.QueryWhere(ctx, where...)
+	if err != nil {
+		return nil, err
+	}
+	return project(states, find), nil
+}
+
+// Len reports the number of stored triples.
+func (s *SQLiteStore) Len(ctx context.Context) (n int, err error) {
+	err = s.db.ReadTX(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "SELECT COUNT(*) FROM datalog_facts", &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				n = stmt.ColumnInt(0)
+				return nil
+			},
+		})
+	})
+	return n, err
+}
+
+func queryWhereSQLite(tx *sqlite.Conn, where []Clause) ([]State, error) {
+	var (
+		patterns []Pattern
+		filters  []Clause
+	)
+	for _, clause := range where {
+		if pattern, ok := clause.(Pattern); ok {
+			patterns = append(patterns, pattern)
+		} else {
+			filters = append(filters, clause)
+		}
+	}
+
+	q := &sqlQuery{vars: map[string]sqlColumn{}}
+	q.addPatterns("t", patterns, nil)
+
+	var goFilters []Clause
+	for _, clause := range filters {
+		if not, ok := clause.(NotClause); ok && onlyPatterns(not.Clauses) {
+			q.addNotExists(not)
+			continue
+		}
+		goFilters = append(goFilters, clause)
+	}
+
+	states, err := q.run(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, clause := range goFilters {
+		revised := states[:0]
+		for _, state := range states {
+			keep, err := sqliteFilter(tx, clause, state)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				revised = append(revised, state)
+			}
+		}
+		states = revised
+	}
+	return states, nil
+}
+
+// sqliteFilter reports whether state satisfies a predicate or a negation that
+// could not be compiled to SQL.
+func sqliteFilter(tx *sqlite.Conn, clause Clause, state State) (bool, error) {
+	switch clause := clause.(type) {
+	case Predicate:
+		return len(clause.solve(nil, nil, state)) > 0, nil
+	case NotClause:
+		inner := make([]Clause, len(clause.Clauses))
+		for i, c := range clause.Clauses {
+			inner[i] = bindClause(c, state)
+		}
+		matches, err := queryWhereSQLite(tx, inner)
+		return len(matches) == 0, err
+	default:
+		return false, fmt.Errorf("datalog: unsupported clause %T", clause)
+	}
+}
+
+// bindClause substitutes the variables bound in state into clause.
+func bindClause(clause Clause, state State) Clause {
+	switch clause := clause.(type) {
+	case Pattern:
+		return bindPattern(clause, state)
+	case Predicate:
+		bound := clause
+		bound.Args = make([]Value, len(clause.Args))
+		for i, arg := range clause.Args {
+			bound.Args[i] = arg
+			if isVariable(arg) {
+				if value, ok := state[arg.(string)]; ok {
+					bound.Args[i] = value
+				}
+			}
+		}
+		return bound
+	case NotClause:
+		inner := make([]Clause, len(clause.Clauses))
+		for i, c := range clause.Clauses {
+			inner[i] = bindClause(c, state)
+		}
+		return NotClause{Clauses: inner}
+	default:
+		return clause
+	}
+}
+
+func onlyPatterns(clauses []Clause) bool {
+	for _, clause := range clauses {
+		if _, ok := clause.(Pattern); !ok {
+			return false
+		}
+	}
+	return len(clauses) > 0
+}
+
+type sqlColumn struct {
+	alias    string
+	position int
+}
+
+func (c sqlColumn) value() string { return c.alias + "." + sqliteColumns[c.position] }
+func (c sqlColumn) kind() string  { return c.value() + "k" }
+func (c sqlColumn) ref() string {
+	return "((" + c.alias + ".refs >> " + strconv.Itoa(c.position) + ") & 1)"
+}
+
+type sqlQuery struct {
+	from  []string
+	where []string
+	args  []any
+	vars  map[string]sqlColumn
+	order []string
+	nots  int
+}
+
+// addPatterns joins one aliased copy of the fact table per pattern. Variables
+// found in outer are correlated with an enclosing query instead of being
+// bound here.
+func (q *sqlQuery) addPatterns(prefix string, patterns []Pattern, outer map[string]sqlColumn) {
+	for i, pattern := range patterns {
+		alias := prefix + strconv.Itoa(i)
+		q.from = append(q.from, "datalog_facts "+alias)
+		for position, part := range pattern {
+			column := sqlColumn{alias: alias, position: position}
+			if !isVariable(part) {
+				value, kind, _ := encodeSQLiteValue(part)
+				q.where = append(q.where, column.value()+" = ? AND "+column.kind()+" = ?")
+				q.args = append(q.args, value, int64(kind))
+				continue
+			}
+
+			name := part.(string)
+			first, ok := outer[name]
+			if !ok {
+				first, ok = q.vars[name]
+			}
+			if !ok {
+				q.vars[name] = column
+				q.order = append(q.order, name)
+				continue
+			}
+			q.where = append(q.where, column.value()+" = "+first.value()+" AND "+column.kind()+" = "+first.kind())
+		}
+	}
+}
+
+func (q *sqlQuery) addNotExists(not NotClause) {
+	patterns := make([]Pattern, len(not.Clauses))
+	for i, clause := range not.Clauses {
+		patterns[i] = clause.(Pattern)
+	}
+
+	q.nots++
+	inner := &sqlQuery{vars: map[string]sqlColumn{}}
+	inner.addPatterns("n"+strconv.Itoa(q.nots)+"_", patterns, q.vars)
+
+	sql := "NOT EXISTS (SELECT 1 FROM " + strings.Join(inner.from, ", ")
+	if len(inner.where) > 0 {
+		sql += " WHERE " + strings.Join(inner.where, " AND ")
+	}
+	q.where = append(q.where, sql+")")
+	q.args = append(q.args, inner.args...)
+}
+
+func (q *sqlQuery) run(tx *sqlite.Conn) ([]State, error) {
+	if len(q.from) == 0 {
+		return []State{{}}, nil
+	}
+
+	selects := make([]string, 0, 3*len(q.order))
+	for _, name := range q.order {
+		column := q.vars[name]
+		selects = append(selects, column.value(), column.kind(), column.ref())
+	}
+	if len(selects) == 0 {
+		selects = append(selects, "1")
+	}
+
+	sql := "SELECT " + strings.Join(selects, ", ") + " FROM " + strings.Join(q.from, ", ")
+	if len(q.where) > 0 {
+		sql += " WHERE " + strings.Join(q.where, " AND ")
+	}
+
+	var states []State
+	err := sqlitex.Execute(tx, sql, &sqlitex.ExecOptions{
+		Args: q.args,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			state := make(State, len(q.order))
+			for i, name := range q.order {
+				state[name] = decodeSQLiteValue(stmt, 3*i)
+			}
+			states = append(states, state)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datalog facts: %w", err)
+	}
+	return states, nil
+}
+
+// encodeSQLiteValue converts v to a value SQLite can bind along with the kind
+// used for equality. Refs are stored with the string kind and reported
+// separately so they join with plain entity ids.
+func encodeSQLiteValue(v Value) (value any, kind Kind, isRef bool) {
+	switch v := normalizeValue(v).(type) {
+	case Ref:
+		return string(v), KindString, true
+	case int64:
+		return v, KindInt, false
+	case float64:
+		return v, KindFloat, false
+	case bool:
+		return v, KindBool, false
+	case time.Time:
+		return encodeSQLiteTime(v), KindTime, false
+	default:
+		return FormatValue(v), KindString, false
+	}
+}
+
+// encodeSQLiteTime stores t as its Unix seconds and nanoseconds, which unlike
+// UnixNano covers every time.Time. Times are UTC after normalization, so
+// equal instants encode the same.
+func encodeSQLiteTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + "." + fmt.Sprintf("%09d", t.Nanosecond())
+}
+
+// decodeSQLiteTime reverses encodeSQLiteTime. Text it can not parse is
+// returned as is rather than as a zero time.
+func decodeSQLiteTime(s string) Value {
+	secs, nanos, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return s
+	}
+	nsec, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return s
+	}
+	return time.Unix(sec, nsec).UTC()
+}
+
+func encodeSQLiteTriple(triple Triple) (args []any, refs int64) {
+	args = make([]any, 0, 7)
+	for position, part := range triple {
+		value, kind, isRef := encodeSQLiteValue(part)
+		args = append(args, value, int64(kind))
+		if isRef {
+			refs |= 1 << position
+		}
+	}
+	return args, refs
+}
+
+// decodeSQLiteValue reads the value, kind and ref flag selected starting at
+// col.
+func decodeSQLiteValue(stmt *sqlite.Stmt, col int) Value {
+	switch Kind(stmt.ColumnInt64(col + 1)) {
+	case KindInt:
+		return stmt.ColumnInt64(col)
+	case KindFloat:
+		return stmt.ColumnFloat(col)
+	case KindBool:
+		return stmt.ColumnInt64(col) != 0
+	case KindTime:
+		return decodeSQLiteTime(stmt.ColumnText(col))
+	default:
+		if stmt.ColumnInt64(col+2) != 0 {
+			return Ref(stmt.ColumnText(col))
+		}
+		return stmt.ColumnText(col)
+	}
+}
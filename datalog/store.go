@@ -0,0 +1,78 @@
+package datalog
+
+import (
+	"context"
+	"fmt"
+)
+
+// Store is the interface shared by the in-memory DB and the persistent
+// SQLiteStore, so code can be tested against memory and run against disk.
+type Store interface {
+	// Assert adds triples, ignoring the ones that are already stored. It
+	// returns ErrInvalidValue and stores nothing if a triple can not be
+	// stored.
+	Assert(ctx context.Context, triples ...Triple) error
+	// Retract removes triples, ignoring the ones that are not stored.
+	Retract(ctx context.Context, triples ...Triple) error
+	// QueryWhere returns every state that satisfies all of the clauses.
+	QueryWhere(ctx context.Context, where ...Clause) ([]State, error)
+	// QueryValues returns the find elements of every matching state, see
+	// DB.QueryValues.
+	QueryValues(ctx context.Context, find []string, where ...Clause) ([][]Value, error)
+}
+
+// MemoryStore adapts a DB to the Store interface.
+type MemoryStore struct {
+	db *DB
+}
+
+// NewMemoryStore wraps db. Rules registered on db are visible to queries
+// made through the store.
+func NewMemoryStore(db *DB) *MemoryStore {
+	return &MemoryStore{db: db}
+}
+
+// DB returns the wrapped database.
+func (s *MemoryStore) DB() *DB {
+	return s.db
+}
+
+func (s *MemoryStore) Assert(ctx context.Context, triples ...Triple) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, triple := range triples {
+		if err := checkTriple(triple); err != nil {
+			return fmt.Errorf("failed to assert %v: %w", triple, err)
+		}
+	}
+	s.db.AssertBatch(triples...)
+	return nil
+}
+
+func (s *MemoryStore) Retract(ctx context.Context, triples ...Triple) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.db.RetractBatch(triples...)
+	return nil
+}
+
+func (s *MemoryStore) QueryWhere(ctx context.Context, where ...Clause) ([]State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.db.QueryWhere(where...), nil
+}
+
+func (s *MemoryStore) QueryValues(ctx context.Context, find []string, where ...Clause) ([][]Value, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.db.QueryValues(find, where...), nil
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
@@ -0,0 +1,286 @@
+package datalog
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value is a single part of a triple or pattern. Supported dynamic types are
+// string, int64, float64, bool, time.Time and Ref. Other integer and float
+// types are widened to int64 and float64 when stored, and any other type is
+// stored as its fmt.Sprint form. Unsigned integers above math.MaxInt64 and nil
+// can not be stored, see ErrInvalidValue. Plain strings remain the common case, and a
+// string that starts with "?" is a variable when used in a pattern. Use
+// Literal for a constant string that starts with "?".
+type Value any
+
+// ErrInvalidValue is returned, or panicked with by the methods that do not
+// return errors, when a triple holds nil or an unsigned integer that does not
+// fit in an int64.
+var ErrInvalidValue = errors.New("datalog: invalid value")
+
+// checkValue reports values that can not be stored without changing them.
+func checkValue(v Value) error {
+	switch v := v.(type) {
+	case nil:
+		return fmt.Errorf("%w: nil", ErrInvalidValue)
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return fmt.Errorf("%w: %d overflows int64", ErrInvalidValue, v)
+		}
+	case uint64:
+		if v > math.MaxInt64 {
+			return fmt.Errorf("%w: %d overflows int64", ErrInvalidValue, v)
+		}
+	}
+	return nil
+}
+
+func checkTriple(triple Triple) error {
+	for _, part := range triple {
+		if err := checkValue(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mustCheckTriples(triples []Triple) {
+	for _, triple := range triples {
+		if err := checkTriple(triple); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Literal is a string constant that is never read as a variable, even when it
+// starts with "?". It is stored and compared as the plain string.
+type Literal string
+
+// Ref is a value that refers to another entity by id. Refs compare equal to
+// the plain string id, so a ref in the value position joins against the
+// entity position of other triples.
+type Ref string
+
+// Kind classifies a Value by its dynamic type.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+	KindTime
+	KindRef
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindBool:
+		return "bool"
+	case KindTime:
+		return "time"
+	case KindRef:
+		return "ref"
+	default:
+		return "Kind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// KindOf reports the kind of v after normalization.
+func KindOf(v Value) Kind {
+	switch normalizeValue(v).(type) {
+	case int64:
+		return KindInt
+	case float64:
+		return KindFloat
+	case bool:
+		return KindBool
+	case time.Time:
+		return KindTime
+	case Ref:
+		return KindRef
+	default:
+		return KindString
+	}
+}
+
+// normalizeValue widens v to one of the supported dynamic types. Values that
+// fail checkValue can still be compared: unsigned integers above
+// math.MaxInt64 become float64 and nil stays nil.
+func normalizeValue(v Value) Value {
+	switch v := v.(type) {
+	case string, int64, float64, bool, Ref:
+		return v
+	case Literal:
+		return string(v)
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return float64(v)
+		}
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			return float64(v)
+		}
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Time:
+		// UTC also strips the monotonic reading so equal instants compare
+		// equal with ==.
+		return v.UTC()
+	case nil:
+		return nil
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// valueKey returns the comparable form of v used for equality and as an index
+// key.
+func valueKey(v Value) Value {
+	v = normalizeValue(v)
+	if ref, ok := v.(Ref); ok {
+		return string(ref)
+	}
+	return v
+}
+
+func normalizeTriple(triple Triple) Triple {
+	return Triple{normalizeValue(triple[0]), normalizeValue(triple[1]), normalizeValue(triple[2])}
+}
+
+func tripleKey(triple Triple) Triple {
+	return Triple{valueKey(triple[0]), valueKey(triple[1]), valueKey(triple[2])}
+}
+
+// Equal reports whether a and b are the same value.
+func Equal(a, b Value) bool {
+	return valueKey(a) == valueKey(b)
+}
+
+// Compare orders two values natively. Integers and floats compare
+// numerically with each other, strings and refs compare lexically with each
+// other and times compare chronologically. Values of unrelated kinds are
+// ordered by kind.
+func Compare(a, b Value) int {
+	a, b = valueKey(a), valueKey(b)
+
+	if af, aok := numeric(a); aok {
+		if bf, bok := numeric(b); bok {
+			ai, aInt := a.(int64)
+			bi, bInt := b.(int64)
+			if aInt && bInt {
+				return cmp.Compare(ai, bi)
+			}
+			return cmp.Compare(af, bf)
+		}
+	}
+
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0
+			case !a:
+				return -1
+			default:
+				return 1
+			}
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	}
+
+	return cmp.Compare(kindRank(a), kindRank(b))
+}
+
+func numeric(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return math.NaN(), false
+	}
+}
+
+// kindRank orders unrelated kinds, treating refs as strings.
+func kindRank(v Value) int {
+	switch KindOf(v) {
+	case KindBool:
+		return 0
+	case KindInt, KindFloat:
+		return 1
+	case KindTime:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// FormatValue renders v as a string. Strings and refs are returned as is,
+// times use RFC 3339 with nanoseconds and a []Value produced by the distinct
+// aggregate is rendered as a bracketed, space separated list.
+func FormatValue(v Value) string {
+	if values, ok := v.([]Value); ok {
+		parts := make([]string, len(values))
+		for i, value := range values {
+			parts[i] = FormatValue(value)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	}
+
+	switch v := normalizeValue(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case Ref:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
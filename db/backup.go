@@ -0,0 +1,278 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// BackupProgress reports how far a backup has got, in database pages.
+type BackupProgress struct {
+	Remaining int
+	PageCount int
+}
+
+// Done reports whether every page has been copied.
+func (p BackupProgress) Done() bool {
+	return p.Remaining == 0
+}
+
+type backupOptions struct {
+	stepPages  int
+	stepDelay  time.Duration
+	onProgress func(BackupProgress)
+	onError    func(error)
+}
+
+type BackupOption func(*backupOptions)
+
+const (
+	defaultBackupStepPages = 256
+	// backupBusyDelay is the least a backup waits before retrying a step that
+	// found the source busy or locked.
+	backupBusyDelay = 10 * time.Millisecond
+)
+
+// BackupWithStepPages sets how many pages are copied per step. A negative
+// value copies the whole database in one step.
+func BackupWithStepPages(pages int) BackupOption {
+	return func(o *backupOptions) {
+		o.stepPages = pages
+	}
+}
+
+// BackupWithStepDelay pauses between steps to limit the I/O a backup takes
+// away from the live database.
+func BackupWithStepDelay(delay time.Duration) BackupOption {
+	return func(o *backupOptions) {
+		o.stepDelay = delay
+	}
+}
+
+// BackupWithProgress calls fn after every step.
+func BackupWithProgress(fn func(BackupProgress)) BackupOption {
+	return func(o *backupOptions) {
+		o.onProgress = fn
+	}
+}
+
+// BackupWithErrorHandler makes ScheduleBackups report failed backups to fn
+// and keep going instead of returning the first error.
+func BackupWithErrorHandler(fn func(error)) BackupOption {
+	return func(o *backupOptions) {
+		o.onError = fn
+	}
+}
+
+func newBackupOptions(opts []BackupOption) backupOptions {
+	options := backupOptions{stepPages: defaultBackupStepPages}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.stepPages == 0 {
+		options.stepPages = defaultBackupStepPages
+	}
+	return options
+}
+
+// Backup writes a consistent copy of the database to dstPath using the SQLite
+// online backup API. Writes can continue while the backup runs; the copy
+// reflects the database as of the start of the backup. The copy is written
+// next to dstPath, synced and renamed into place once complete, so dstPath
+// only ever holds a whole backup.
+func (db *Database) Backup(ctx context.Context, dstPath string, opts ...BackupOption) (err error) {
+	options := newBackupOptions(opts)
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return fmt.Errorf("could not create backup directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create backup file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not create backup file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := db.backupToFile(ctx, tmpPath, options); err != nil {
+		return err
+	}
+	if err := syncPath(tmpPath); err != nil {
+		return fmt.Errorf("could not sync backup file: %w", err)
+	}
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		return fmt.Errorf("could not move backup into place: %w", err)
+	}
+	if err := syncPath(filepath.Dir(dstPath)); err != nil {
+		return fmt.Errorf("could not sync backup directory: %w", err)
+	}
+	return nil
+}
+
+// syncPath flushes the file or directory at path to stable storage.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// BackupTo streams a consistent copy of the database to w. The copy is staged
+// in a temporary file first since SQLite needs a database to back up into.
+func (db *Database) BackupTo(ctx context.Context, w io.Writer, opts ...BackupOption) error {
+	dir, err := os.MkdirTemp("", "backup")
+	if err != nil {
+		return fmt.Errorf("could not create backup directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, filepath.Base(db.filename))
+	if err := db.backupToFile(ctx, path, newBackupOptions(opts)); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open backup file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	return nil
+}
+
+// ScheduleBackups runs Backup every interval until ctx is done, writing each
+// copy to the path returned by dstPath for the time of the backup. Without
+// BackupWithErrorHandler the first failed backup stops the schedule and is
+// returned.
+func (db *Database) ScheduleBackups(ctx context.Context, interval time.Duration, dstPath func(time.Time) string, opts ...BackupOption) error {
+	options := newBackupOptions(opts)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			if err := db.Backup(ctx, dstPath(now), opts...); err != nil {
+				if options.onError == nil {
+					return fmt.Errorf("scheduled backup failed: %w", err)
+				}
+				options.onError(err)
+			}
+		}
+	}
+}
+
+func (db *Database) backupToFile(ctx context.Context, path string, options backupOptions) (err error) {
+	if db.encrypted != nil {
+		return db.backupEncrypted(path, options)
+	}
+
+	src, err := db.readPool.Take(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to take read connection: %w", err)
+	}
+	if src == nil {
+		return fmt.Errorf("could not get read connection from pool")
+	}
+	defer db.readPool.Put(src)
+
+	// Holding a read transaction pins the snapshot being copied, so writes
+	// made by other connections during the backup do not restart it.
+	endFn := sqlitex.Transaction(src)
+	defer endFn(&err)
+	if err := sqlitex.ExecuteTransient(src, "SELECT COUNT(*) FROM sqlite_master", nil); err != nil {
+		return fmt.Errorf("could not start backup transaction: %w", err)
+	}
+
+	dst, err := sqlite.OpenConn(path, sqlite.OpenReadWrite, sqlite.OpenCreate)
+	if err != nil {
+		return fmt.Errorf("could not open backup database: %w", err)
+	}
+	defer func() {
+		if closeErr := dst.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not close backup database: %w", closeErr)
+		}
+	}()
+
+	backup, err := sqlite.NewBackup(dst, "main", src, "main")
+	if err != nil {
+		return fmt.Errorf("could not start backup: %w", err)
+	}
+	defer func() {
+		if closeErr := backup.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not finish backup: %w", closeErr)
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		more, err := backup.Step(options.stepPages)
+		if err != nil && !more {
+			return fmt.Errorf("could not copy backup pages: %w", err)
+		}
+		if options.onProgress != nil {
+			options.onProgress(BackupProgress{
+				Remaining: backup.Remaining(),
+				PageCount: backup.PageCount(),
+			})
+		}
+		if !more {
+			return nil
+		}
+
+		delay := options.stepDelay
+		if err != nil {
+			// The source was busy or locked and the step will be retried.
+			delay = max(delay, backupBusyDelay)
+		}
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// backupEncrypted copies the encrypted database file, which always holds the
+// latest committed state, so the backup stays encrypted with the same key.
+func (db *Database) backupEncrypted(path string, options backupOptions) error {
+	data, err := os.ReadFile(db.filename)
+	if err != nil {
+		return fmt.Errorf("could not read encrypted database: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	if options.onProgress != nil {
+		options.onProgress(BackupProgress{})
+	}
+	return nil
+}
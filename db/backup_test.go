@@ -0,0 +1,145 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+var backupTestMigrations = []string{
+	"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)",
+}
+
+// seedNotes fills the notes table with enough rows to take several backup
+// steps.
+func seedNotes(t *testing.T, db *Database) {
+	t.Helper()
+	require.NoError(t, db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		for i := range 100 {
+			if err := sqlitex.Execute(tx, "INSERT INTO notes (id, body) VALUES (?, ?)", &sqlitex.ExecOptions{
+				Args: []any{i, strings.Repeat("x", 1000)},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func TestBackup(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations(backupTestMigrations))
+	seedNotes(t, db)
+	ctx := context.Background()
+
+	var progress []BackupProgress
+	dst := filepath.Join(t.TempDir(), "nested", "backup.sqlite")
+	require.NoError(t, db.Backup(ctx, dst,
+		BackupWithStepPages(8),
+		BackupWithProgress(func(p BackupProgress) { progress = append(progress, p) }),
+	))
+
+	require.Greater(t, len(progress), 1)
+	assert.False(t, progress[0].Done())
+	assert.True(t, progress[len(progress)-1].Done())
+	assert.Positive(t, progress[len(progress)-1].PageCount)
+
+	copied, err := NewDatabase(ctx, DatabaseWithFilename(dst))
+	require.NoError(t, err)
+	defer copied.Close()
+	assert.Equal(t, 100, countRows(t, copied, "notes"))
+
+	leftovers, err := filepath.Glob(dst + ".*.tmp")
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestBackupCancelledRemovesTempFile(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations(backupTestMigrations))
+	seedNotes(t, db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	err := db.Backup(ctx, filepath.Join(dir, "backup.sqlite"),
+		BackupWithStepPages(1),
+		BackupWithProgress(func(BackupProgress) { cancel() }),
+	)
+	require.ErrorIs(t, err, context.Canceled)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBackupTo(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations(backupTestMigrations))
+	seedNotes(t, db)
+
+	var buf bytes.Buffer
+	require.NoError(t, db.BackupTo(context.Background(), &buf))
+
+	dst := filepath.Join(t.TempDir(), "streamed.sqlite")
+	require.NoError(t, os.WriteFile(dst, buf.Bytes(), 0o600))
+	copied, err := NewDatabase(context.Background(), DatabaseWithFilename(dst))
+	require.NoError(t, err)
+	defer copied.Close()
+	assert.Equal(t, 100, countRows(t, copied, "notes"))
+}
+
+func TestScheduleBackupsKeepsGoingWithErrorHandler(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations(backupTestMigrations))
+	seedNotes(t, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A path below a regular file can never be created.
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o600))
+
+	var calls, failures, successes atomic.Int64
+	dstPath := func(time.Time) string {
+		if calls.Add(1)%2 == 1 {
+			return filepath.Join(blocker, "backup.sqlite")
+		}
+		successes.Add(1)
+		return filepath.Join(dir, "backup.sqlite")
+	}
+	onError := func(error) {
+		if failures.Add(1) == 2 {
+			cancel()
+		}
+	}
+
+	err := db.ScheduleBackups(ctx, 10*time.Millisecond, dstPath, BackupWithErrorHandler(onError))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(2), failures.Load())
+	assert.GreaterOrEqual(t, successes.Load(), int64(1))
+	assert.FileExists(t, filepath.Join(dir, "backup.sqlite"))
+}
+
+func TestScheduleBackupsStopsWithoutErrorHandler(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations(backupTestMigrations))
+	seedNotes(t, db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o600))
+
+	err := db.ScheduleBackups(ctx, 10*time.Millisecond, func(time.Time) string {
+		return filepath.Join(blocker, "backup.sqlite")
+	})
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"zombiezen.com/go/sqlite"
+)
+
+// ChangeOp is the kind of row change.
+type ChangeOp string
+
+const (
+	ChangeInsert ChangeOp = "insert"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// Change is a single row changed by a committed write transaction.
+type Change struct {
+	Table string
+	Op    ChangeOp
+	// Key holds the primary key columns of the row, in table order.
+	Key []any
+	// RowID is the key when the primary key is a single integer column, which
+	// for an INTEGER PRIMARY KEY is the rowid, and 0 otherwise.
+	RowID int64
+	// Old and New hold the columns of the row before and after the change
+	// when DatabaseWithChangeValues is set. Old is nil for inserts and New is
+	// nil for deletes. For updates only the changed columns are set, plus the
+	// key columns in Old; the others are nil.
+	Old []any
+	New []any
+}
+
+// ChangeSet holds the changes made by one committed write transaction, in
+// the order SQLite recorded them.
+type ChangeSet struct {
+	CommittedAt time.Time
+	Changes     []Change
+}
+
+// DatabaseWithChanges emits a ChangeSet on bus after every WriteTX that
+// changed at least one row. Changes are captured with the SQLite session
+// extension, so only tables with a PRIMARY KEY are tracked, and writes made
+// through WriteWithoutTx are not captured.
+func DatabaseWithChanges(bus toolbelt.EventBus[ChangeSet]) DatabaseOption {
+	return func(o *databaseOptions) {
+		o.changes = bus
+	}
+}
+
+// DatabaseWithChangeValues includes the before and after column values in
+// each Change.
+func DatabaseWithChangeValues(include bool) DatabaseOption {
+	return func(o *databaseOptions) {
+		o.changeValues = include
+	}
+}
+
+type changeCapture struct {
+	session *sqlite.Session
+}
+
+func newChangeCapture(conn *sqlite.Conn) (*changeCapture, error) {
+	session, err := conn.CreateSession("")
+	if err != nil {
+		return nil, fmt.Errorf("could not create change session: %w", err)
+	}
+	if err := session.Attach(""); err != nil {
+		session.Delete()
+		return nil, fmt.Errorf("could not attach change session: %w", err)
+	}
+	return &changeCapture{session: session}, nil
+}
+
+func (c *changeCapture) close() {
+	c.session.Delete()
+}
+
+// collect returns the changes recorded by the session.
+func (c *changeCapture) collect(withValues bool) ([]Change, error) {
+	var buf bytes.Buffer
+	if err := c.session.WriteChangeset(&buf); err != nil {
+		return nil, fmt.Errorf("could not read changeset: %w", err)
+	}
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+	return readChangeset(&buf, withValues)
+}
+
+func readChangeset(buf *bytes.Buffer, withValues bool) (changes []Change, err error) {
+	iter, err := sqlite.NewChangesetIterator(buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not iterate changeset: %w", err)
+	}
+	defer func() {
+		if closeErr := iter.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not close changeset iterator: %w", closeErr)
+		}
+	}()
+
+	for {
+		more, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("could not iterate changeset: %w", err)
+		}
+		if !more {
+			return changes, nil
+		}
+
+		change, err := readChange(iter, withValues)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+}
+
+func readChange(iter *sqlite.ChangesetIterator, withValues bool) (Change, error) {
+	op, err := iter.Operation()
+	if err != nil {
+		return Change{}, fmt.Errorf("could not read change: %w", err)
+	}
+	pk, err := iter.PrimaryKey()
+	if err != nil {
+		return Change{}, fmt.Errorf("could not read change key: %w", err)
+	}
+
+	change := Change{Table: op.TableName}
+	hasOld, hasNew := true, true
+	switch op.Type {
+	case sqlite.OpInsert:
+		change.Op, hasOld = ChangeInsert, false
+	case sqlite.OpUpdate:
+		change.Op = ChangeUpdate
+	case sqlite.OpDelete:
+		change.Op, hasNew = ChangeDelete, false
+	default:
+		return Change{}, fmt.Errorf("unexpected change operation %v", op.Type)
+	}
+
+	row := func(get func(int) (sqlite.Value, error)) ([]any, error) {
+		values := make([]any, op.NumColumns)
+		for col := range values {
+			v, err := get(col)
+			if err != nil {
+				return nil, fmt.Errorf("could not read change value: %w", err)
+			}
+			values[col] = changeValue(v)
+		}
+		return values, nil
+	}
+
+	var before, after []any
+	if hasOld {
+		if before, err = row(iter.Old); err != nil {
+			return Change{}, err
+		}
+	}
+	if hasNew {
+		if after, err = row(iter.New); err != nil {
+			return Change{}, err
+		}
+	}
+
+	// Key columns are always present in the old row of updates and deletes
+	// and in the new row of inserts.
+	keyRow := before
+	if !hasOld {
+		keyRow = after
+	}
+	for col, isKey := range pk {
+		if isKey {
+			change.Key = append(change.Key, keyRow[col])
+		}
+	}
+	if len(change.Key) == 1 {
+		if id, ok := change.Key[0].(int64); ok {
+			change.RowID = id
+		}
+	}
+
+	if withValues {
+		change.Old, change.New = before, after
+	}
+	return change, nil
+}
+
+func changeValue(v sqlite.Value) any {
+	switch v.Type() {
+	case sqlite.TypeInteger:
+		return v.Int64()
+	case sqlite.TypeFloat:
+		return v.Float()
+	case sqlite.TypeText:
+		return v.Text()
+	case sqlite.TypeBlob:
+		return v.Blob()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/delaneyj/toolbelt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+var changesTestMigrations = []string{
+	"CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL, qty INTEGER NOT NULL)",
+	"CREATE TABLE pairs (a TEXT NOT NULL, b INTEGER NOT NULL, v TEXT, PRIMARY KEY (a, b))",
+	"CREATE TABLE untracked (v TEXT)",
+}
+
+// collectChanges returns a bus that records the change sets emitted on it.
+func collectChanges() (toolbelt.EventBus[ChangeSet], *[]ChangeSet) {
+	bus := toolbelt.NewEventBusSync[ChangeSet]()
+	var sets []ChangeSet
+	bus.Subscribe(context.Background(), func(set ChangeSet) error {
+		sets = append(sets, set)
+		return nil
+	})
+	return bus, &sets
+}
+
+func execWrite(t *testing.T, db *Database, query string, args ...any) {
+	t.Helper()
+	require.NoError(t, db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, query, &sqlitex.ExecOptions{Args: args})
+	}))
+}
+
+func TestChangesWithValues(t *testing.T) {
+	bus, sets := collectChanges()
+	db := newTestDatabase(t, DatabaseWithMigrations(changesTestMigrations), DatabaseWithChanges(bus), DatabaseWithChangeValues(true))
+
+	execWrite(t, db, "INSERT INTO items (id, name, qty) VALUES (7, 'apple', 1)")
+	execWrite(t, db, "UPDATE items SET qty = 2 WHERE id = 7")
+	execWrite(t, db, "DELETE FROM items WHERE id = 7")
+
+	require.Len(t, *sets, 3)
+	for _, set := range *sets {
+		assert.False(t, set.CommittedAt.IsZero())
+		require.Len(t, set.Changes, 1)
+	}
+
+	assert.Equal(t, Change{
+		Table: "items",
+		Op:    ChangeInsert,
+		Key:   []any{int64(7)},
+		RowID: 7,
+		New:   []any{int64(7), "apple", int64(1)},
+	}, (*sets)[0].Changes[0])
+	assert.Equal(t, Change{
+		Table: "items",
+		Op:    ChangeUpdate,
+		Key:   []any{int64(7)},
+		RowID: 7,
+		Old:   []any{int64(7), nil, int64(1)},
+		New:   []any{nil, nil, int64(2)},
+	}, (*sets)[1].Changes[0])
+	assert.Equal(t, Change{
+		Table: "items",
+		Op:    ChangeDelete,
+		Key:   []any{int64(7)},
+		RowID: 7,
+		Old:   []any{int64(7), "apple", int64(2)},
+	}, (*sets)[2].Changes[0])
+}
+
+func TestChangesWithoutValues(t *testing.T) {
+	bus, sets := collectChanges()
+	db := newTestDatabase(t, DatabaseWithMigrations(changesTestMigrations), DatabaseWithChanges(bus), DatabaseWithChangeValues(false))
+
+	execWrite(t, db, "INSERT INTO items (id, name, qty) VALUES (1, 'apple', 1)")
+	execWrite(t, db, "UPDATE items SET name = 'pear' WHERE id = 1")
+
+	require.Len(t, *sets, 2)
+	assert.Equal(t, []Change{{Table: "items", Op: ChangeInsert, Key: []any{int64(1)}, RowID: 1}}, (*sets)[0].Changes)
+	assert.Equal(t, []Change{{Table: "items", Op: ChangeUpdate, Key: []any{int64(1)}, RowID: 1}}, (*sets)[1].Changes)
+}
+
+func TestChangesCompositeKey(t *testing.T) {
+	bus, sets := collectChanges()
+	db := newTestDatabase(t, DatabaseWithMigrations(changesTestMigrations), DatabaseWithChanges(bus), DatabaseWithChangeValues(false))
+
+	require.NoError(t, db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.ExecuteScript(tx, `
+			INSERT INTO pairs (a, b, v) VALUES ('x', 1, 'one');
+			INSERT INTO pairs (a, b, v) VALUES ('x', 2, 'two');
+			INSERT INTO untracked (v) VALUES ('ignored');
+		`, nil)
+	}))
+
+	require.Len(t, *sets, 1)
+	changes := (*sets)[0].Changes
+	require.Len(t, changes, 2)
+	for _, change := range changes {
+		assert.Equal(t, "pairs", change.Table)
+		assert.Equal(t, ChangeInsert, change.Op)
+		assert.Zero(t, change.RowID)
+	}
+	assert.ElementsMatch(t, [][]any{{"x", int64(1)}, {"x", int64(2)}}, [][]any{changes[0].Key, changes[1].Key})
+}
+
+func TestChangesSkipRolledBackAndUntracked(t *testing.T) {
+	bus, sets := collectChanges()
+	db := newTestDatabase(t, DatabaseWithMigrations(changesTestMigrations), DatabaseWithChanges(bus), DatabaseWithChangeValues(true))
+	ctx := context.Background()
+
+	errRollback := errors.New("rollback")
+	err := db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		if err := sqlitex.Execute(tx, "INSERT INTO items (id, name, qty) VALUES (1, 'apple', 1)", nil); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	require.NoError(t, db.WriteWithoutTx(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO items (id, name, qty) VALUES (2, 'pear', 1)", nil)
+	}))
+	execWrite(t, db, "INSERT INTO untracked (v) VALUES ('ignored')")
+
+	assert.Empty(t, *sets)
+	assert.Equal(t, 1, countRows(t, db, "items"))
+}
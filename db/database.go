@@ -12,8 +12,10 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/delaneyj/toolbelt"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitemigration"
@@ -26,13 +28,35 @@ type Database struct {
 	writePool  *sqlitex.Pool
 	readPool   *sqlitex.Pool
 	pragmas    []string
+
+	versionedMigrations []Migration
+	retryPolicy         RetryPolicy
+	slowTxThreshold     time.Duration
+	writeMetrics        *poolMetrics
+	readMetrics         *poolMetrics
+	encryptionKey       string
+	encrypted           *encryptedFile
+	persistErr          atomic.Pointer[error]
+	inMemory            bool
+	memory              *memoryDatabase
+
+	changes      toolbelt.EventBus[ChangeSet]
+	changeValues bool
 }
 
 type databaseOptions struct {
-	filename    string
-	migrations  []string
-	pragmas     []string
-	shouldClear bool
+	filename     string
+	migrations   []string
+	pragmas      []string
+	shouldClear  bool
+	changes      toolbelt.EventBus[ChangeSet]
+	changeValues bool
+
+	versionedMigrations []Migration
+	retryPolicy         RetryPolicy
+	slowTxThreshold     time.Duration
+	encryptionKey       string
+	inMemory            bool
 }
 
 type DatabaseOption func(*databaseOptions)
@@ -78,6 +102,9 @@ func normalizePragma(pragma string) string {
 
 type TxFn func(tx *sqlite.Conn) error
 
+// ErrDatabaseClosed is returned when using a database after Close.
+var ErrDatabaseClosed = errors.New("database is closed")
+
 func NewDatabase(ctx context.Context, opts ...DatabaseOption) (*Database, error) {
 	options := databaseOptions{}
 	for _, opt := range opts {
@@ -91,11 +118,27 @@ func NewDatabase(ctx context.Context, opts ...DatabaseOption) (*Database, error)
 	if options.filename == "" {
 		options.filename = defaultDatabaseFilename
 	}
+	if options.inMemory && options.encryptionKey != "" {
+		return nil, errors.New("in-memory databases can not be encrypted")
+	}
+	if err := validateMigrations(options.versionedMigrations); err != nil {
+		return nil, err
+	}
 
 	db := &Database{
-		filename:   options.filename,
-		migrations: options.migrations,
-		pragmas:    options.pragmas,
+		filename:     options.filename,
+		migrations:   options.migrations,
+		pragmas:      options.pragmas,
+		changes:      options.changes,
+		changeValues: options.changeValues,
+
+		versionedMigrations: options.versionedMigrations,
+		retryPolicy:         options.retryPolicy,
+		slowTxThreshold:     options.slowTxThreshold,
+		writeMetrics:        newPoolMetrics(),
+		readMetrics:         newPoolMetrics(),
+		encryptionKey:       options.encryptionKey,
+		inMemory:            options.inMemory,
 	}
 
 	if err := db.Reset(ctx, options.shouldClear); err != nil {
@@ -105,11 +148,18 @@ func NewDatabase(ctx context.Context, opts ...DatabaseOption) (*Database, error)
 	return db, nil
 }
 
+// Path returns the database filename, or "" for an in-memory database.
 func (db *Database) Path() string {
+	if db.inMemory {
+		return ""
+	}
 	return db.filename
 }
 
 func (db *Database) WriteWithoutTx(ctx context.Context, fn TxFn) error {
+	if err := db.checkPersisted(); err != nil {
+		return err
+	}
 	conn, err := db.writePool.Take(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to take write connection: %w", err)
@@ -123,32 +173,21 @@ func (db *Database) WriteWithoutTx(ctx context.Context, fn TxFn) error {
 		return fmt.Errorf("could not execute write transaction: %w", err)
 	}
 
-	return nil
+	return db.persist(conn)
 }
 
 func (db *Database) Reset(ctx context.Context, shouldClear bool) (err error) {
-	if err := db.Close(); err != nil {
+	if err := db.closePools(); err != nil {
 		return fmt.Errorf("could not close database: %w", err)
 	}
 
-	if shouldClear {
-		dbFiles, err := filepath.Glob(db.filename + "*")
-		if err != nil {
-			return fmt.Errorf("could not glob database files: %w", err)
-		}
-		for _, file := range dbFiles {
-			if err := os.Remove(file); err != nil {
-				return fmt.Errorf("could not remove database file: %w", err)
-			}
-		}
-	}
-
-	if err := os.MkdirAll(filepath.Dir(db.filename), 0o755); err != nil {
-		return fmt.Errorf("could not create database directory: %w", err)
+	uri, err := db.open(shouldClear)
+	if err != nil {
+		return err
 	}
+	db.persistErr.Store(nil)
 
-	uri := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL", db.filename)
-
+	db.writeMetrics.size.Store(1)
 	db.writePool, err = sqlitex.NewPool(uri, sqlitex.PoolOptions{
 		PoolSize: 1,
 		PrepareConn: func(conn *sqlite.Conn) error {
@@ -165,10 +204,19 @@ func (db *Database) Reset(ctx context.Context, shouldClear bool) (err error) {
 		return fmt.Errorf("could not open write pool: %w", err)
 	}
 
+	db.readMetrics.size.Store(int64(runtime.NumCPU()))
 	db.readPool, err = sqlitex.NewPool(uri, sqlitex.PoolOptions{
 		PoolSize: runtime.NumCPU(),
 	})
 
+	if len(db.versionedMigrations) > 0 {
+		latest := db.versionedMigrations[len(db.versionedMigrations)-1].Version
+		if err := db.MigrateTo(ctx, latest); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		return nil
+	}
+
 	schema := sqlitemigration.Schema{Migrations: db.migrations}
 	conn, err := db.writePool.Take(ctx)
 	if err != nil {
@@ -183,6 +231,9 @@ func (db *Database) Reset(ctx context.Context, shouldClear bool) (err error) {
 	if err := sqlitemigration.Migrate(ctx, conn, schema); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if err := db.persist(conn); err != nil {
+		return err
+	}
 
 	db.writePool.Put(conn)
 	conn = nil
@@ -190,51 +241,203 @@ func (db *Database) Reset(ctx context.Context, shouldClear bool) (err error) {
 	return nil
 }
 
-func (db *Database) Close() error {
+// open prepares the storage behind the pools and returns the uri they
+// connect to.
+func (db *Database) open(shouldClear bool) (string, error) {
+	if db.inMemory {
+		if shouldClear && db.memory != nil {
+			if err := db.memory.close(); err != nil {
+				return "", fmt.Errorf("could not close in-memory database: %w", err)
+			}
+			db.memory = nil
+		}
+		if db.memory == nil {
+			m, err := openMemoryDatabase(newMemoryDatabaseName())
+			if err != nil {
+				return "", err
+			}
+			db.memory = m
+		}
+		return db.memory.uri, nil
+	}
+
+	if db.memory != nil {
+		if err := db.memory.close(); err != nil {
+			return "", fmt.Errorf("could not close in-memory database: %w", err)
+		}
+		db.memory, db.encrypted = nil, nil
+	}
+
+	if shouldClear {
+		dbFiles, err := filepath.Glob(db.filename + "*")
+		if err != nil {
+			return "", fmt.Errorf("could not glob database files: %w", err)
+		}
+		for _, file := range dbFiles {
+			if err := os.Remove(file); err != nil {
+				return "", fmt.Errorf("could not remove database file: %w", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(db.filename), 0o755); err != nil {
+		return "", fmt.Errorf("could not create database directory: %w", err)
+	}
+
+	if db.encryptionKey == "" {
+		return fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL", db.filename), nil
+	}
+
+	f, plaintext, err := openEncryptedFile(db.filename, db.encryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("could not open encrypted database: %w", err)
+	}
+	m, err := openMemoryDatabase(newMemoryDatabaseName())
+	if err != nil {
+		return "", err
+	}
+	if len(plaintext) > 0 {
+		if err := m.load(plaintext); err != nil {
+			m.close()
+			return "", fmt.Errorf("could not load decrypted database: %w", err)
+		}
+	}
+	db.encrypted, db.memory = f, m
+	return m.uri, nil
+}
+
+func (db *Database) closePools() error {
 	errs := []error{}
 	if db.writePool != nil {
 		errs = append(errs, db.writePool.Close())
+		db.writePool = nil
 	}
 
 	if db.readPool != nil {
 		errs = append(errs, db.readPool.Close())
+		db.readPool = nil
 	}
 
 	return errors.Join(errs...)
 }
 
-func (db *Database) WriteTX(ctx context.Context, fn TxFn) (err error) {
-	conn, err := db.writePool.Take(ctx)
+func (db *Database) Close() error {
+	errs := []error{db.closePools()}
+
+	if db.memory != nil {
+		errs = append(errs, db.memory.close())
+		db.memory, db.encrypted = nil, nil
+	}
+
+	return errors.Join(errs...)
+}
+
+func (db *Database) WriteTX(ctx context.Context, fn TxFn) error {
+	return db.writeTXThen(ctx, fn, nil)
+}
+
+// writeTXThen is WriteTX, calling committed once the transaction commits and
+// before its changes are emitted, which may still fail.
+func (db *Database) writeTXThen(ctx context.Context, fn TxFn, committed func()) error {
+	var changes []Change
+	if err := db.retry(ctx, "write", func() (err error) {
+		changes, err = db.writeTXOnce(ctx, fn)
+		return err
+	}); err != nil {
+		return err
+	}
+	if committed != nil {
+		committed()
+	}
+
+	if len(changes) > 0 {
+		if err := db.changes.Emit(ctx, ChangeSet{CommittedAt: time.Now(), Changes: changes}); err != nil {
+			return fmt.Errorf("transaction committed but changes were not delivered: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) writeTXOnce(ctx context.Context, fn TxFn) (changes []Change, err error) {
+	if err := db.checkPersisted(); err != nil {
+		return nil, err
+	}
+	conn, err := db.writeMetrics.take(ctx, db.writePool, "write")
 	if err != nil {
-		return fmt.Errorf("failed to take write connection: %w", err)
+		return nil, err
 	}
-	if conn == nil {
-		return fmt.Errorf("could not get write connection from pool")
+	defer db.writeMetrics.put(db.writePool, conn)
+
+	var capture *changeCapture
+	if db.changes != nil {
+		if capture, err = newChangeCapture(conn); err != nil {
+			return nil, err
+		}
+		defer capture.close()
 	}
-	defer db.writePool.Put(conn)
 
+	start := time.Now()
+	rows, err := writeTX(conn, db.limitSize(fn))
+	db.writeMetrics.observe(ctx, "write", db.slowTxThreshold, time.Since(start), rows, err)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.persist(conn); err != nil {
+		return nil, err
+	}
+
+	if capture != nil {
+		return capture.collect(db.changeValues)
+	}
+	return nil, nil
+}
+
+// writeTX runs fn in an immediate transaction on conn and reports the number
+// of rows it changed.
+func writeTX(conn *sqlite.Conn, fn TxFn) (rows int64, err error) {
 	endFn, err := sqlitex.ImmediateTransaction(conn)
 	if err != nil {
-		return fmt.Errorf("could not start transaction: %w", err)
+		return -1, fmt.Errorf("could not start transaction: %w", err)
 	}
 	defer endFn(&err)
 
+	before, err := totalChanges(conn)
+	if err != nil {
+		return -1, fmt.Errorf("could not count changes: %w", err)
+	}
+
 	if err := fn(conn); err != nil {
-		return fmt.Errorf("could not execute write transaction: %w", err)
+		return -1, fmt.Errorf("could not execute write transaction: %w", err)
 	}
 
-	return nil
+	after, err := totalChanges(conn)
+	if err != nil {
+		return -1, fmt.Errorf("could not count changes: %w", err)
+	}
+	return after - before, nil
 }
 
-func (db *Database) ReadTX(ctx context.Context, fn TxFn) (err error) {
-	conn, err := db.readPool.Take(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to take read connection: %w", err)
+func (db *Database) ReadTX(ctx context.Context, fn TxFn) error {
+	return db.retry(ctx, "read", func() error {
+		return db.readTX(ctx, db.readPool, db.readMetrics, fn)
+	})
+}
+
+func (db *Database) readTX(ctx context.Context, pool *sqlitex.Pool, metrics *poolMetrics, fn TxFn) (err error) {
+	if err := db.checkPersisted(); err != nil {
+		return err
 	}
-	if conn == nil {
-		return fmt.Errorf("could not get read connection from pool")
+	conn, err := metrics.take(ctx, pool, "read")
+	if err != nil {
+		return err
 	}
-	defer db.readPool.Put(conn)
+	defer metrics.put(pool, conn)
+
+	start := time.Now()
+	defer func() {
+		metrics.observe(ctx, "read", db.slowTxThreshold, time.Since(start), -1, err)
+	}()
 
 	endFn := sqlitex.Transaction(conn)
 	defer endFn(&err)
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// newTestDatabase opens a database in a temporary directory that is closed
+// when the test ends.
+func newTestDatabase(t *testing.T, opts ...DatabaseOption) *Database {
+	t.Helper()
+	opts = append([]DatabaseOption{DatabaseWithFilename(filepath.Join(t.TempDir(), "test.sqlite"))}, opts...)
+	db, err := NewDatabase(context.Background(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// countRows returns the number of rows in table.
+func countRows(t *testing.T, db *Database, table string) int {
+	t.Helper()
+	var n int
+	require.NoError(t, db.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.ExecuteTransient(tx, "SELECT COUNT(*) FROM "+table, &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				n = stmt.ColumnInt(0)
+				return nil
+			},
+		})
+	}))
+	return n
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/delaneyj/toolbelt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// DatabaseWithEncryption keeps the database file encrypted at rest with
+// ChaCha20-Poly1305, using a key derived from key with toolbelt.DeriveKey.
+//
+// This is whole-image encryption, not page-level encryption: the database is
+// decrypted into an in-memory database when opened, and after every committed
+// write the whole image is re-encrypted, synced and atomically swapped in, so
+// plaintext never reaches the disk. Each write costs time proportional to the
+// size of the database, so it is meant for small databases holding sensitive
+// data and is limited to MaxEncryptedDatabaseSize: larger files are refused
+// with ErrEncryptedTooLarge, as is a write transaction that would grow the
+// database past it. A write whose image can not be saved is still visible in
+// memory, so the Database then fails every call with ErrNotPersisted until
+// Reset reloads the last saved image.
+func DatabaseWithEncryption(key string) DatabaseOption {
+	return func(o *databaseOptions) {
+		o.encryptionKey = key
+	}
+}
+
+// ErrDecrypt is returned when an encrypted database can not be opened with
+// the given key, or the file was tampered with.
+var ErrDecrypt = errors.New("could not decrypt database, wrong key or corrupted file")
+
+// MaxEncryptedDatabaseSize is the largest database DatabaseWithEncryption
+// accepts, in bytes of SQLite image.
+const MaxEncryptedDatabaseSize = 64 << 20
+
+// ErrEncryptedTooLarge is returned when an encrypted database would exceed
+// MaxEncryptedDatabaseSize.
+var ErrEncryptedTooLarge = fmt.Errorf("encrypted database exceeds %d bytes", MaxEncryptedDatabaseSize)
+
+// ErrNotPersisted is returned by every call on an encrypted database after a
+// committed write could not be saved, see DatabaseWithEncryption.
+var ErrNotPersisted = errors.New("encrypted database has unsaved writes, reset it to reload the last saved state")
+
+// encryptedFileMagic starts every encrypted database file, followed by the
+// argon2 salt, the nonce and the sealed SQLite database image.
+const (
+	encryptedFileMagic = "TBDBENC1"
+	encryptedSaltSize  = 16
+
+	encryptedHeaderSize = len(encryptedFileMagic) + encryptedSaltSize + chacha20poly1305.NonceSize
+)
+
+type encryptedFile struct {
+	path string
+	salt []byte
+	aead cipher.AEAD
+}
+
+// openEncryptedFile derives the key for path and decrypts its contents, if
+// the file exists.
+func openEncryptedFile(path, key string) (f *encryptedFile, plaintext []byte, err error) {
+	f = &encryptedFile{path: path}
+
+	info, err := os.Stat(path)
+	if err == nil && info.Size() > int64(MaxEncryptedDatabaseSize+encryptedHeaderSize+chacha20poly1305.Overhead) {
+		return nil, nil, ErrEncryptedTooLarge
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		f.salt = make([]byte, encryptedSaltSize)
+		if _, err := rand.Read(f.salt); err != nil {
+			return nil, nil, fmt.Errorf("could not generate salt: %w", err)
+		}
+		if f.aead, err = deriveAEAD(key, f.salt); err != nil {
+			return nil, nil, err
+		}
+		return f, nil, nil
+	case err != nil:
+		return nil, nil, fmt.Errorf("could not read encrypted database: %w", err)
+	}
+
+	if !bytes.HasPrefix(data, []byte(encryptedFileMagic)) {
+		return nil, nil, fmt.Errorf("%s is not an encrypted database", path)
+	}
+	data = data[len(encryptedFileMagic):]
+	if len(data) < encryptedSaltSize+chacha20poly1305.NonceSize {
+		return nil, nil, ErrDecrypt
+	}
+	f.salt, data = data[:encryptedSaltSize], data[encryptedSaltSize:]
+	if f.aead, err = deriveAEAD(key, f.salt); err != nil {
+		return nil, nil, err
+	}
+
+	nonce, ciphertext := data[:f.aead.NonceSize()], data[f.aead.NonceSize():]
+	plaintext, err = f.aead.Open(nil, nonce, ciphertext, []byte(encryptedFileMagic))
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	return f, plaintext, nil
+}
+
+func deriveAEAD(key string, salt []byte) (cipher.AEAD, error) {
+	aead, err := chacha20poly1305.New(toolbelt.DeriveKey([]byte(key), salt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chacha20poly1305: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts a database image into the on-disk format.
+func (f *encryptedFile) seal(plaintext []byte) ([]byte, error) {
+	out := make([]byte, 0, len(encryptedFileMagic)+len(f.salt)+f.aead.NonceSize()+len(plaintext)+f.aead.Overhead())
+	out = append(out, encryptedFileMagic...)
+	out = append(out, f.salt...)
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return f.aead.Seal(out, nonce, plaintext, []byte(encryptedFileMagic)), nil
+}
+
+// save encrypts the database conn is attached to and atomically replaces
+// path with it.
+func (f *encryptedFile) save(conn *sqlite.Conn, path string) error {
+	plaintext, err := conn.Serialize("main")
+	if err != nil {
+		return fmt.Errorf("could not serialize database: %w", err)
+	}
+	if len(plaintext) > MaxEncryptedDatabaseSize {
+		return ErrEncryptedTooLarge
+	}
+	sealed, err := f.seal(plaintext)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create encrypted database: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write encrypted database: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync encrypted database: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write encrypted database: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace encrypted database: %w", err)
+	}
+	return nil
+}
+
+// persist re-encrypts the database after a write. conn must be the write
+// connection so no other write can interleave. A failure leaves the in-memory
+// database ahead of the file, so the database refuses further use.
+func (db *Database) persist(conn *sqlite.Conn) error {
+	if db.encrypted == nil {
+		return nil
+	}
+	if err := db.encrypted.save(conn, db.filename); err != nil {
+		err = fmt.Errorf("failed to persist encrypted database: %w", err)
+		db.persistErr.Store(&err)
+		return errors.Join(ErrNotPersisted, err)
+	}
+	return nil
+}
+
+// limitSize wraps fn for encrypted databases so a transaction that grows the
+// database past MaxEncryptedDatabaseSize fails and rolls back, rather than
+// committing a write that can not be saved.
+func (db *Database) limitSize(fn TxFn) TxFn {
+	if db.encrypted == nil {
+		return fn
+	}
+	return func(tx *sqlite.Conn) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		var size int64
+		if err := sqlitex.ExecuteTransient(tx, "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()", &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				size = stmt.ColumnInt64(0)
+				return nil
+			},
+		}); err != nil {
+			return fmt.Errorf("could not measure encrypted database: %w", err)
+		}
+		if size > MaxEncryptedDatabaseSize {
+			return ErrEncryptedTooLarge
+		}
+		return nil
+	}
+}
+
+// checkPersisted returns ErrNotPersisted after a failed persist.
+func (db *Database) checkPersisted() error {
+	if err := db.persistErr.Load(); err != nil {
+		return errors.Join(ErrNotPersisted, *err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+const encryptionTestKey = "correct horse battery staple"
+
+var encryptionTestMigrations = []string{
+	"CREATE TABLE secrets (id INTEGER PRIMARY KEY, body TEXT NOT NULL)",
+}
+
+// encryptedAt returns the options opening the encrypted test database at
+// path.
+func encryptedAt(path string) []DatabaseOption {
+	return []DatabaseOption{
+		DatabaseWithFilename(path),
+		DatabaseWithEncryption(encryptionTestKey),
+		DatabaseWithMigrations(encryptionTestMigrations),
+	}
+}
+
+func insertSecret(db *Database, body string) error {
+	return db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO secrets (body) VALUES (?)", &sqlitex.ExecOptions{Args: []any{body}})
+	})
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.sqlite")
+	db := newTestDatabase(t, encryptedAt(path)...)
+	require.NoError(t, insertSecret(db, "launch codes"))
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), encryptedFileMagic))
+	assert.NotContains(t, string(data), "launch codes")
+	assert.NotContains(t, string(data), "SQLite format")
+
+	reopened := newTestDatabase(t, encryptedAt(path)...)
+	assert.Equal(t, 1, countRows(t, reopened, "secrets"))
+
+	_, err = NewDatabase(context.Background(), DatabaseWithFilename(path), DatabaseWithEncryption("wrong"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestEncryptionHandlesAreIndependent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.sqlite")
+	first := newTestDatabase(t, encryptedAt(path)...)
+	require.NoError(t, insertSecret(first, "one"))
+
+	// Opening the same file again must not replace the first handle's data.
+	second := newTestDatabase(t, encryptedAt(path)...)
+	require.NoError(t, insertSecret(first, "two"))
+	assert.Equal(t, 2, countRows(t, first, "secrets"))
+	assert.Equal(t, 1, countRows(t, second, "secrets"))
+}
+
+func TestEncryptionFailedPersistPoisons(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	path := filepath.Join(dir, "secret.sqlite")
+	db := newTestDatabase(t, encryptedAt(path)...)
+	require.NoError(t, insertSecret(db, "saved"))
+
+	// Without its directory the image can not be written.
+	moved := dir + ".moved"
+	require.NoError(t, os.Rename(dir, moved))
+	err := insertSecret(db, "lost")
+	require.ErrorIs(t, err, ErrNotPersisted)
+	assert.ErrorIs(t, db.ReadTX(context.Background(), func(*sqlite.Conn) error { return nil }), ErrNotPersisted)
+	assert.ErrorIs(t, insertSecret(db, "refused"), ErrNotPersisted)
+
+	require.NoError(t, os.Rename(moved, dir))
+	require.NoError(t, db.Reset(context.Background(), false))
+	assert.Equal(t, 1, countRows(t, db, "secrets"))
+	require.NoError(t, insertSecret(db, "after reset"))
+}
+
+func TestEncryptionPersistsEachMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.sqlite")
+	migrations := []Migration{
+		{Version: 1, Name: "secrets", Up: encryptionTestMigrations[0]},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE broken (;"},
+	}
+	_, err := NewDatabase(context.Background(),
+		DatabaseWithFilename(path),
+		DatabaseWithEncryption(encryptionTestKey),
+		DatabaseWithVersionedMigrations(migrations),
+	)
+	require.Error(t, err)
+
+	db, err := NewDatabase(context.Background(),
+		DatabaseWithFilename(path),
+		DatabaseWithEncryption(encryptionTestKey),
+		DatabaseWithVersionedMigrations(migrations[:1]),
+	)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []int{1}, appliedVersions(t, db))
+}
+
+func TestEncryptionRefusesLargeDatabases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.sqlite")
+	db := newTestDatabase(t, encryptedAt(path)...)
+	require.NoError(t, insertSecret(db, "small"))
+
+	err := db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO secrets (body) VALUES (zeroblob(?))", &sqlitex.ExecOptions{
+			Args: []any{MaxEncryptedDatabaseSize},
+		})
+	})
+	require.ErrorIs(t, err, ErrEncryptedTooLarge)
+	// The write was rolled back, so the database is still usable.
+	assert.Equal(t, 1, countRows(t, db, "secrets"))
+	require.NoError(t, insertSecret(db, "after"))
+
+	large := filepath.Join(t.TempDir(), "large.sqlite")
+	require.NoError(t, os.WriteFile(large, []byte(encryptedFileMagic), 0o644))
+	require.NoError(t, os.Truncate(large, 2*MaxEncryptedDatabaseSize))
+	_, err = NewDatabase(context.Background(), DatabaseWithFilename(large), DatabaseWithEncryption(encryptionTestKey))
+	assert.ErrorIs(t, err, ErrEncryptedTooLarge)
+}
+
+func TestEncryptionMigrationStatusPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.sqlite")
+	db := newTestDatabase(t, encryptedAt(path)...)
+	_, err := db.MigrationStatus(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	reopened := newTestDatabase(t, encryptedAt(path)...)
+	assert.True(t, tableExists(t, reopened, "_migrations"))
+}
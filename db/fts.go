@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// FTSIndex describes an FTS5 full-text index over columns of a table. The
+// index stores no copy of the text, it reads it from the table, and triggers
+// keep it in sync on every insert, update and delete. The table must have a
+// rowid.
+type FTSIndex struct {
+	Table   string
+	Columns []string
+	// Tokenizer is passed to the tokenize option of FTS5, for example
+	// "porter unicode61". It defaults to the FTS5 default.
+	Tokenizer string
+}
+
+// Name returns the name of the FTS5 table, the table name suffixed with _fts.
+func (idx FTSIndex) Name() string {
+	return idx.Table + "_fts"
+}
+
+// Migration returns a versioned migration that creates the index, its
+// triggers, and indexes the rows already in the table. Down drops them.
+func (idx FTSIndex) Migration(version int) Migration {
+	return Migration{
+		Version: version,
+		Name:    "fts_" + idx.Table,
+		Up:      idx.UpScript(),
+		Down:    idx.DownScript(),
+	}
+}
+
+// UpScript returns the SQL that creates the index, for use with
+// DatabaseWithMigrations.
+func (idx FTSIndex) UpScript() string {
+	name := quoteIdent(idx.Name())
+	table := quoteIdent(idx.Table)
+	columns := make([]string, len(idx.Columns))
+	for i, c := range idx.Columns {
+		columns[i] = quoteIdent(c)
+	}
+	cols := strings.Join(columns, ", ")
+	newCols := "new." + strings.Join(columns, ", new.")
+	oldCols := "old." + strings.Join(columns, ", old.")
+
+	options := fmt.Sprintf("content=%s, content_rowid='rowid'", quoteString(idx.Table))
+	if idx.Tokenizer != "" {
+		options += ", tokenize=" + quoteString(idx.Tokenizer)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE VIRTUAL TABLE %s USING fts5(%s, %s);\n", name, cols, options)
+	fmt.Fprintf(&sb, "INSERT INTO %s(%s) VALUES ('rebuild');\n", name, name)
+	fmt.Fprintf(&sb, `CREATE TRIGGER %s AFTER INSERT ON %s BEGIN
+	INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);
+END;
+`, quoteIdent(idx.Name()+"_ai"), table, name, cols, newCols)
+	fmt.Fprintf(&sb, `CREATE TRIGGER %s AFTER DELETE ON %s BEGIN
+	INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);
+END;
+`, quoteIdent(idx.Name()+"_ad"), table, name, name, cols, oldCols)
+	fmt.Fprintf(&sb, `CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN
+	INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s);
+	INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s);
+END;
+`, quoteIdent(idx.Name()+"_au"), table, name, name, cols, oldCols, name, cols, newCols)
+	return sb.String()
+}
+
+// DownScript returns the SQL that drops the index and its triggers.
+func (idx FTSIndex) DownScript() string {
+	var sb strings.Builder
+	for _, suffix := range []string{"_ai", "_ad", "_au"} {
+		fmt.Fprintf(&sb, "DROP TRIGGER IF EXISTS %s;\n", quoteIdent(idx.Name()+suffix))
+	}
+	fmt.Fprintf(&sb, "DROP TABLE IF EXISTS %s;\n", quoteIdent(idx.Name()))
+	return sb.String()
+}
+
+// SearchResult is one row matched by a full-text search.
+type SearchResult struct {
+	RowID int64
+	// Score is the bm25 rank of the match. Lower is more relevant.
+	Score float64
+	// Snippet is the best matching fragment of the indexed columns, with the
+	// matched terms wrapped in the highlight markers.
+	Snippet string
+}
+
+type ftsOptions struct {
+	highlightStart string
+	highlightEnd   string
+	ellipsis       string
+	snippetTokens  int
+}
+
+type FTSOption func(*ftsOptions)
+
+// FTSWithHighlight sets the markers matched terms are wrapped in within
+// snippets. They default to <b> and </b>.
+func FTSWithHighlight(start, end string) FTSOption {
+	return func(o *ftsOptions) {
+		o.highlightStart = start
+		o.highlightEnd = end
+	}
+}
+
+// maxSnippetTokens is the most tokens the FTS5 snippet function accepts.
+const maxSnippetTokens = 64
+
+// FTSWithSnippetTokens sets the maximum number of tokens in a snippet, which
+// is clamped to between 1 and 64. It defaults to 16.
+func FTSWithSnippetTokens(tokens int) FTSOption {
+	return func(o *ftsOptions) {
+		o.snippetTokens = min(max(tokens, 1), maxSnippetTokens)
+	}
+}
+
+// FTS searches one full-text index of a database.
+type FTS struct {
+	db      *Database
+	index   FTSIndex
+	options ftsOptions
+}
+
+// FTS returns a searcher for index, which must have been created by one of
+// its migrations.
+func (db *Database) FTS(index FTSIndex, opts ...FTSOption) *FTS {
+	options := ftsOptions{
+		highlightStart: "<b>",
+		highlightEnd:   "</b>",
+		ellipsis:       "…",
+		snippetTokens:  16,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &FTS{db: db, index: index, options: options}
+}
+
+// Search runs an FTS5 query and returns up to limit matches, most relevant
+// first. A limit of zero or less returns every match. Hydrate the rows with
+// ReadTX using the returned rowids.
+func (f *FTS) Search(ctx context.Context, query string, limit int) (results []SearchResult, err error) {
+	if err := f.db.ReadTX(ctx, func(tx *sqlite.Conn) error {
+		results, err = f.SearchTx(tx, query, limit)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SearchTx is Search within an existing transaction.
+func (f *FTS) SearchTx(tx *sqlite.Conn, query string, limit int) ([]SearchResult, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	name := quoteIdent(f.index.Name())
+	stmt := fmt.Sprintf(`
+		SELECT rowid, bm25(%s), snippet(%s, -1, ?, ?, ?, ?)
+		FROM %s
+		WHERE %s MATCH ?
+		ORDER BY bm25(%s)
+		LIMIT ?`, name, name, name, name, name)
+
+	var results []SearchResult
+	if err := sqlitex.Execute(tx, stmt, &sqlitex.ExecOptions{
+		Args: []any{
+			f.options.highlightStart, f.options.highlightEnd, f.options.ellipsis, f.options.snippetTokens,
+			query, limit,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			results = append(results, SearchResult{
+				RowID:   stmt.ColumnInt64(0),
+				Score:   stmt.ColumnFloat(1),
+				Snippet: stmt.ColumnText(2),
+			})
+			return nil
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to search %s: %w", f.index.Name(), err)
+	}
+	return results, nil
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
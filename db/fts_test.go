@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+var ftsTestIndex = FTSIndex{
+	Table:     "articles",
+	Columns:   []string{"title", "body"},
+	Tokenizer: "porter unicode61",
+}
+
+var ftsTestMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "articles",
+		Up:      "CREATE TABLE articles (id INTEGER PRIMARY KEY, title TEXT NOT NULL, body TEXT NOT NULL);",
+		Down:    "DROP TABLE articles;",
+	},
+	ftsTestIndex.Migration(2),
+}
+
+func writeArticles(t *testing.T, db *Database, query string, args ...any) {
+	t.Helper()
+	require.NoError(t, db.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, query, &sqlitex.ExecOptions{Args: args})
+	}))
+}
+
+func searchRowIDs(t *testing.T, fts *FTS, query string) []int64 {
+	t.Helper()
+	results, err := fts.Search(context.Background(), query, 0)
+	require.NoError(t, err)
+	ids := make([]int64, len(results))
+	for i, r := range results {
+		ids[i] = r.RowID
+	}
+	return ids
+}
+
+func TestFTSTriggersKeepIndexInSync(t *testing.T) {
+	db := newTestDatabase(t, DatabaseInMemory(), DatabaseWithVersionedMigrations(ftsTestMigrations))
+	fts := db.FTS(ftsTestIndex)
+
+	writeArticles(t, db, "INSERT INTO articles (id, title, body) VALUES (1, 'Gophers', 'Gophers dig tunnels')")
+	writeArticles(t, db, "INSERT INTO articles (id, title, body) VALUES (2, 'Otters', 'Otters swim in rivers')")
+	assert.Equal(t, []int64{1}, searchRowIDs(t, fts, "tunnel"))
+	assert.Equal(t, []int64{2}, searchRowIDs(t, fts, "swimming"))
+
+	writeArticles(t, db, "UPDATE articles SET body = 'Gophers swim too' WHERE id = 1")
+	assert.Empty(t, searchRowIDs(t, fts, "tunnels"))
+	assert.ElementsMatch(t, []int64{1, 2}, searchRowIDs(t, fts, "swim"))
+
+	writeArticles(t, db, "DELETE FROM articles WHERE id = 2")
+	assert.Equal(t, []int64{1}, searchRowIDs(t, fts, "swim"))
+
+	// Rolling back the index drops the table and its triggers.
+	require.NoError(t, db.MigrateTo(context.Background(), 1))
+	assert.False(t, tableExists(t, db, ftsTestIndex.Name()))
+	writeArticles(t, db, "INSERT INTO articles (id, title, body) VALUES (3, 'Moles', 'Moles dig')")
+
+	// Creating it again indexes the rows already in the table.
+	require.NoError(t, db.MigrateTo(context.Background(), 2))
+	assert.ElementsMatch(t, []int64{1, 3}, searchRowIDs(t, fts, "gopher OR mole"))
+}
+
+func TestFTSSearchRanksAndSnippets(t *testing.T) {
+	db := newTestDatabase(t, DatabaseInMemory(), DatabaseWithVersionedMigrations(ftsTestMigrations))
+	writeArticles(t, db, `INSERT INTO articles (id, title, body) VALUES
+		(1, 'Cooking', 'A recipe for bread with a mention of sqlite'),
+		(2, 'Databases', 'sqlite sqlite sqlite, all about sqlite'),
+		(3, 'Gardening', 'Nothing relevant here')`)
+
+	fts := db.FTS(ftsTestIndex, FTSWithHighlight("[", "]"))
+	results, err := fts.Search(context.Background(), "sqlite", 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, int64(2), results[0].RowID)
+	assert.Equal(t, int64(1), results[1].RowID)
+	assert.Less(t, results[0].Score, results[1].Score)
+	assert.Contains(t, results[0].Snippet, "[sqlite]")
+	assert.Contains(t, results[1].Snippet, "[sqlite]")
+
+	limited, err := fts.Search(context.Background(), "sqlite", 1)
+	require.NoError(t, err)
+	assert.Equal(t, results[:1], limited)
+
+	_, err = fts.Search(context.Background(), `"unterminated`, 0)
+	assert.Error(t, err)
+}
+
+func TestFTSSnippetTokensAreClamped(t *testing.T) {
+	db := newTestDatabase(t, DatabaseInMemory(), DatabaseWithVersionedMigrations(ftsTestMigrations))
+	long := strings.Repeat("word ", 100) + "needle"
+	writeArticles(t, db, "INSERT INTO articles (id, title, body) VALUES (1, 'Long', ?)", long)
+
+	for tokens, want := range map[int]int{-5: 1, 0: 1, 8: 8, 1000: maxSnippetTokens} {
+		fts := db.FTS(ftsTestIndex, FTSWithSnippetTokens(tokens), FTSWithHighlight("", ""))
+		assert.Equal(t, want, fts.options.snippetTokens)
+
+		results, err := fts.Search(context.Background(), "needle", 0)
+		require.NoError(t, err, "tokens %d", tokens)
+		require.Len(t, results, 1)
+		assert.Contains(t, results[0].Snippet, "needle")
+		assert.LessOrEqual(t, len(strings.Fields(strings.ReplaceAll(results[0].Snippet, "…", " "))), want)
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// DatabaseInMemory keeps the database in memory instead of a file, which
+// suits tests. The read and write pools share one in-memory database, so
+// migrations, ReadTX and WriteTX behave as they do on disk. Every Database
+// gets its own in-memory database, which lives until Close; Reset with
+// shouldClear starts over with an empty one.
+func DatabaseInMemory() DatabaseOption {
+	return func(o *databaseOptions) {
+		o.inMemory = true
+	}
+}
+
+var memoryDatabaseSeq atomic.Uint64
+
+// memoryDatabase is a named in-memory database that every connection opened
+// with its uri shares. The holder connection keeps it alive while the pools
+// come and go.
+type memoryDatabase struct {
+	uri    string
+	holder *sqlite.Conn
+}
+
+func openMemoryDatabase(name string) (*memoryDatabase, error) {
+	m := &memoryDatabase{uri: "file:/" + name + "?vfs=memdb"}
+	holder, err := sqlite.OpenConn(m.uri, sqlite.OpenReadWrite, sqlite.OpenCreate, sqlite.OpenURI)
+	if err != nil {
+		return nil, fmt.Errorf("could not open in-memory database: %w", err)
+	}
+	m.holder = holder
+	return m, nil
+}
+
+// newMemoryDatabaseName returns a name no other in-memory database in the
+// process uses.
+func newMemoryDatabaseName() string {
+	return fmt.Sprintf("toolbelt-memory-%d", memoryDatabaseSeq.Add(1))
+}
+
+// load replaces the contents of the in-memory database with a serialized
+// database image.
+func (m *memoryDatabase) load(image []byte) error {
+	src, err := sqlite.OpenConn(":memory:")
+	if err != nil {
+		return fmt.Errorf("could not open in-memory database: %w", err)
+	}
+	defer src.Close()
+	if err := src.Deserialize("main", image); err != nil {
+		return fmt.Errorf("could not load database image: %w", err)
+	}
+
+	backup, err := sqlite.NewBackup(m.holder, "main", src, "main")
+	if err != nil {
+		return fmt.Errorf("could not load database image: %w", err)
+	}
+	if _, err := backup.Step(-1); err != nil {
+		backup.Close()
+		return fmt.Errorf("could not load database image: %w", err)
+	}
+	if err := backup.Close(); err != nil {
+		return fmt.Errorf("could not load database image: %w", err)
+	}
+	return nil
+}
+
+func (m *memoryDatabase) close() error {
+	if m.holder == nil {
+		return nil
+	}
+	err := m.holder.Close()
+	m.holder = nil
+	return err
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestDatabaseInMemory(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t, DatabaseInMemory(), DatabaseWithMigrations([]string{
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)",
+	}))
+	assert.Empty(t, db.Path())
+
+	// Writes on the write pool are visible to the read pool.
+	require.NoError(t, db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO notes (body) VALUES ('hello')", nil)
+	}))
+	assert.Equal(t, 1, countRows(t, db, "notes"))
+
+	// Reset without clearing keeps the data.
+	require.NoError(t, db.Reset(ctx, false))
+	assert.Equal(t, 1, countRows(t, db, "notes"))
+
+	// Clearing starts over and runs the migrations again.
+	require.NoError(t, db.Reset(ctx, true))
+	assert.Equal(t, 0, countRows(t, db, "notes"))
+}
+
+func TestDatabaseInMemoryIsolated(t *testing.T) {
+	ctx := context.Background()
+	migrations := DatabaseWithMigrations([]string{"CREATE TABLE notes (id INTEGER PRIMARY KEY)"})
+
+	first := newTestDatabase(t, DatabaseInMemory(), migrations)
+	second := newTestDatabase(t, DatabaseInMemory(), migrations)
+
+	require.NoError(t, first.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO notes DEFAULT VALUES", nil)
+	}))
+	assert.Equal(t, 1, countRows(t, first, "notes"))
+	assert.Equal(t, 0, countRows(t, second, "notes"))
+
+	_, err := NewDatabase(ctx, DatabaseInMemory(), DatabaseWithEncryption("key"))
+	assert.Error(t, err)
+}
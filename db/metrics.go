@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// StatsReporter is implemented by anything that can report database
+// statistics, so metrics exporters do not depend on *Database directly.
+type StatsReporter interface {
+	Stats() DatabaseStats
+}
+
+var _ StatsReporter = (*Database)(nil)
+
+// DatabaseStats holds the metrics of both connection pools.
+type DatabaseStats struct {
+	Write PoolStats
+	Read  PoolStats
+}
+
+// PoolStats holds the metrics of one connection pool since the database was
+// created. Counters only increase.
+type PoolStats struct {
+	Size  int
+	InUse int
+	// Transactions counts every attempt, including retried ones.
+	Transactions     uint64
+	Errors           uint64
+	Retries          uint64
+	SlowTransactions uint64
+	// Wait is the time spent waiting for a connection, in seconds.
+	Wait HistogramSnapshot
+	// Duration is the time spent inside transactions, in seconds.
+	Duration HistogramSnapshot
+	// Rows is the number of rows changed per write transaction. It is empty
+	// for the read pool.
+	Rows HistogramSnapshot
+}
+
+// HistogramSnapshot is a point in time copy of a histogram. Bucket counts are
+// cumulative, each counting the observations at or below its upper bound.
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     float64
+	Buckets []HistogramBucket
+}
+
+type HistogramBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+var (
+	durationBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+	rowBuckets      = []float64{0, 1, 10, 100, 1000, 10000}
+)
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.sum += v
+	if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{
+		Count:   h.count,
+		Sum:     h.sum,
+		Buckets: make([]HistogramBucket, len(h.bounds)),
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		s.Buckets[i] = HistogramBucket{UpperBound: bound, Count: cumulative}
+	}
+	return s
+}
+
+type poolMetrics struct {
+	size         atomic.Int64
+	inUse        atomic.Int64
+	transactions atomic.Uint64
+	errors       atomic.Uint64
+	retries      atomic.Uint64
+	slow         atomic.Uint64
+	wait         *histogram
+	duration     *histogram
+	rows         *histogram
+}
+
+func newPoolMetrics() *poolMetrics {
+	return &poolMetrics{
+		wait:     newHistogram(durationBuckets),
+		duration: newHistogram(durationBuckets),
+		rows:     newHistogram(rowBuckets),
+	}
+}
+
+func (m *poolMetrics) stats() PoolStats {
+	return PoolStats{
+		Size:             int(m.size.Load()),
+		InUse:            int(m.inUse.Load()),
+		Transactions:     m.transactions.Load(),
+		Errors:           m.errors.Load(),
+		Retries:          m.retries.Load(),
+		SlowTransactions: m.slow.Load(),
+		Wait:             m.wait.snapshot(),
+		Duration:         m.duration.snapshot(),
+		Rows:             m.rows.snapshot(),
+	}
+}
+
+// take gets a connection from pool, recording the wait.
+func (m *poolMetrics) take(ctx context.Context, pool *sqlitex.Pool, mode string) (*sqlite.Conn, error) {
+	start := time.Now()
+	conn, err := pool.Take(ctx)
+	m.wait.observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to take %s connection: %w", mode, err)
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("could not get %s connection from pool", mode)
+	}
+	m.inUse.Add(1)
+	return conn, nil
+}
+
+func (m *poolMetrics) put(pool *sqlitex.Pool, conn *sqlite.Conn) {
+	m.inUse.Add(-1)
+	pool.Put(conn)
+}
+
+// observe records a finished transaction attempt, logging it through
+// toolbelt.CtxSlog when it took longer than threshold. rows is negative when
+// unknown.
+func (m *poolMetrics) observe(ctx context.Context, mode string, threshold, elapsed time.Duration, rows int64, err error) {
+	m.transactions.Add(1)
+	if err != nil {
+		m.errors.Add(1)
+	}
+	m.duration.observe(elapsed.Seconds())
+	if rows >= 0 {
+		m.rows.observe(float64(rows))
+	}
+
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	m.slow.Add(1)
+	if logger, ok := toolbelt.CtxSlog(ctx); ok {
+		attrs := append(txLogAttrs(ctx, mode), slog.Duration("duration", elapsed), slog.Duration("threshold", threshold))
+		if rows >= 0 {
+			attrs = append(attrs, slog.Int64("rows", rows))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		logger.WarnContext(ctx, "slow transaction", attrs...)
+	}
+}
+
+// Stats reports connection pool and transaction metrics.
+func (db *Database) Stats() DatabaseStats {
+	return DatabaseStats{
+		Write: db.writeMetrics.stats(),
+		Read:  db.readMetrics.stats(),
+	}
+}
+
+// DatabaseWithSlowTxThreshold logs every transaction that takes at least
+// threshold at warn level through toolbelt.CtxSlog, with its duration, the
+// rows it changed and its error. Use WithTxLabel to tell the offending
+// transactions apart.
+func DatabaseWithSlowTxThreshold(threshold time.Duration) DatabaseOption {
+	return func(o *databaseOptions) {
+		o.slowTxThreshold = threshold
+	}
+}
+
+func totalChanges(conn *sqlite.Conn) (n int64, err error) {
+	err = sqlitex.ExecuteTransient(conn, "SELECT total_changes()", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			n = stmt.ColumnInt64(0)
+			return nil
+		},
+	})
+	return n, err
+}
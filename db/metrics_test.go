@@ -0,0 +1,129 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestHistogramSnapshot(t *testing.T) {
+	h := newHistogram([]float64{1, 10, 100})
+	for _, v := range []float64{0.5, 1, 5, 50, 500} {
+		h.observe(v)
+	}
+
+	assert.Equal(t, HistogramSnapshot{
+		Count: 5,
+		Sum:   556.5,
+		Buckets: []HistogramBucket{
+			{UpperBound: 1, Count: 2},
+			{UpperBound: 10, Count: 3},
+			{UpperBound: 100, Count: 4},
+		},
+	}, h.snapshot())
+}
+
+func TestStats(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations([]string{
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)",
+	}))
+	ctx := context.Background()
+	before := db.Stats()
+
+	require.NoError(t, db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		return sqlitex.ExecuteScript(tx, `
+			INSERT INTO notes (body) VALUES ('a');
+			INSERT INTO notes (body) VALUES ('b');
+		`, nil)
+	}))
+	require.Error(t, db.WriteTX(ctx, func(*sqlite.Conn) error { return errors.New("boom") }))
+	require.NoError(t, db.ReadTX(ctx, func(*sqlite.Conn) error { return nil }))
+
+	stats := db.Stats()
+	assert.Equal(t, 1, stats.Write.Size)
+	assert.Positive(t, stats.Read.Size)
+	assert.Zero(t, stats.Write.InUse)
+	assert.Equal(t, before.Write.Transactions+2, stats.Write.Transactions)
+	assert.Equal(t, before.Write.Errors+1, stats.Write.Errors)
+	assert.Equal(t, before.Read.Transactions+1, stats.Read.Transactions)
+	assert.Zero(t, stats.Read.Errors)
+
+	// Only the successful write reports its rows, in the bucket for up to 10.
+	assert.Equal(t, before.Write.Rows.Count+1, stats.Write.Rows.Count)
+	assert.Equal(t, before.Write.Rows.Sum+2, stats.Write.Rows.Sum)
+	assert.Equal(t, rowBuckets[2], stats.Write.Rows.Buckets[2].UpperBound)
+	assert.Equal(t, before.Write.Rows.Buckets[2].Count+1, stats.Write.Rows.Buckets[2].Count)
+	assert.Zero(t, stats.Read.Rows.Count)
+
+	assert.Equal(t, stats.Write.Transactions, stats.Write.Duration.Count)
+	assert.Equal(t, before.Write.Wait.Count+2, stats.Write.Wait.Count)
+	assert.Equal(t, uint64(1), stats.Read.Wait.Count)
+}
+
+func TestStatsCountRetries(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithRetryPolicy(retryTestPolicy))
+	ctx := context.Background()
+
+	fn, _ := busyFor(2)
+	require.NoError(t, db.WriteTX(ctx, fn))
+	fn, _ = busyFor(1)
+	require.NoError(t, db.ReadTX(ctx, fn))
+
+	stats := db.Stats()
+	assert.Equal(t, uint64(2), stats.Write.Retries)
+	assert.Equal(t, uint64(1), stats.Read.Retries)
+}
+
+func TestSlowTransactionLog(t *testing.T) {
+	db := newTestDatabase(t,
+		DatabaseWithMigrations([]string{"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)"}),
+		DatabaseWithSlowTxThreshold(20*time.Millisecond),
+	)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	ctx := WithTxLabel(toolbelt.CtxWithSlog(context.Background(), logger), "import notes")
+
+	require.NoError(t, db.WriteTX(ctx, func(tx *sqlite.Conn) error {
+		if err := sqlitex.Execute(tx, "INSERT INTO notes (body) VALUES ('a')", nil); err != nil {
+			return err
+		}
+		return sqlitex.Execute(tx, "SELECT COUNT(*) FROM notes", &sqlitex.ExecOptions{
+			ResultFunc: func(*sqlite.Stmt) error {
+				time.Sleep(30 * time.Millisecond)
+				return nil
+			},
+		})
+	}))
+	// Fast transactions are not logged.
+	require.NoError(t, db.ReadTX(ctx, func(*sqlite.Conn) error { return nil }))
+
+	var record struct {
+		Level     string
+		Msg       string
+		Tx        string
+		TxMode    string `json:"tx_mode"`
+		Rows      int64
+		Duration  time.Duration
+		Threshold time.Duration
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "WARN", record.Level)
+	assert.Equal(t, "slow transaction", record.Msg)
+	assert.Equal(t, "import notes", record.Tx)
+	assert.Equal(t, "write", record.TxMode)
+	assert.Equal(t, int64(1), record.Rows)
+	assert.GreaterOrEqual(t, record.Duration, 30*time.Millisecond)
+	assert.Equal(t, 20*time.Millisecond, record.Threshold)
+	assert.Equal(t, uint64(1), db.Stats().Write.SlowTransactions)
+}
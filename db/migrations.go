@@ -0,0 +1,323 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Migration is one numbered schema change with the script that undoes it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	// Down reverts Up. Without it the migration can not be rolled back.
+	Down string
+}
+
+// Checksum identifies the contents of the up script, so edits to a migration
+// that was already applied can be detected.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	// ErrMigrationDrift is returned when an applied migration's up script no
+	// longer matches the checksum recorded when it ran.
+	ErrMigrationDrift = errors.New("applied migration was modified")
+	// ErrUnknownMigration is returned when the database has a migration
+	// applied that is not in the configured set.
+	ErrUnknownMigration = errors.New("applied migration is unknown")
+	// ErrIrreversibleMigration is returned when rolling back a migration
+	// without a down script.
+	ErrIrreversibleMigration = errors.New("migration has no down script")
+)
+
+// DatabaseWithVersionedMigrations migrates the database to the latest of the
+// migrations on open, recording each applied migration in a
+// _migrations table. It replaces DatabaseWithMigrations. Versions must be
+// positive and unique, NewDatabase fails otherwise.
+func DatabaseWithVersionedMigrations(migrations []Migration) DatabaseOption {
+	cp := slices.Clone(migrations)
+	slices.SortFunc(cp, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	return func(o *databaseOptions) {
+		o.versionedMigrations = cp
+	}
+}
+
+// validateMigrations checks that the sorted migrations have positive,
+// strictly increasing versions.
+func validateMigrations(migrations []Migration) error {
+	previous := 0
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %q must have a positive version, got %d", m.Name, m.Version)
+		}
+		if m.Version == previous {
+			return fmt.Errorf("migration version %d is used more than once", m.Version)
+		}
+		previous = m.Version
+	}
+	return nil
+}
+
+// VersionedMigrationsFromFS reads migration pairs named like
+// 0001_create_users.up.sql and 0001_create_users.down.sql from dir. Down
+// scripts are optional.
+func VersionedMigrationsFromFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		base, direction, ok := cutLast(base, ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration file %q must end in .up.sql or .down.sql", entry.Name())
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file %q must start with a positive version number", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file: %w", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	return migrations, nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// MigrationState describes one migration in a MigrationStatus report.
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Drifted is set when the migration was applied with a different up
+	// script than the one configured now.
+	Drifted bool
+	// Unknown is set when the migration is applied but not configured.
+	Unknown bool
+}
+
+// MigrationStatus reports every configured migration, and every applied one
+// that is not configured, ordered by version.
+func (db *Database) MigrationStatus(ctx context.Context) (status []MigrationState, err error) {
+	if db.writePool == nil {
+		return nil, ErrDatabaseClosed
+	}
+	conn, err := db.writePool.Take(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take write connection: %w", err)
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("could not get write connection from pool")
+	}
+	defer db.writePool.Put(conn)
+
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+	// appliedMigrations may have created the migrations table.
+	if err := db.persist(conn); err != nil {
+		return nil, err
+	}
+	return migrationStatus(db.versionedMigrations, applied), nil
+}
+
+// MigrateTo applies or rolls back migrations until version is the latest one
+// applied. Version 0 rolls back every migration. Each migration runs in its
+// own transaction, and an encrypted database is saved after each one.
+// Applied migrations that were modified or are unknown stop the migration
+// before anything runs.
+func (db *Database) MigrateTo(ctx context.Context, version int) error {
+	if err := db.checkPersisted(); err != nil {
+		return err
+	}
+	conn, err := db.writePool.Take(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to take write connection: %w", err)
+	}
+	if conn == nil {
+		return fmt.Errorf("could not get write connection from pool")
+	}
+	defer db.writePool.Put(conn)
+
+	if version != 0 && !slices.ContainsFunc(db.versionedMigrations, func(m Migration) bool { return m.Version == version }) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+	for _, state := range migrationStatus(db.versionedMigrations, applied) {
+		switch {
+		case state.Unknown:
+			return fmt.Errorf("migration %d: %w", state.Version, ErrUnknownMigration)
+		case state.Drifted:
+			return fmt.Errorf("migration %d %s: %w", state.Version, state.Name, ErrMigrationDrift)
+		}
+	}
+
+	for _, m := range db.versionedMigrations {
+		if _, ok := applied[m.Version]; ok || m.Version > version {
+			continue
+		}
+		if _, err := writeTX(conn, db.limitSize(func(tx *sqlite.Conn) error {
+			if err := sqlitex.ExecuteScript(tx, m.Up, nil); err != nil {
+				return err
+			}
+			return sqlitex.Execute(tx, `
+				INSERT INTO _migrations (version, name, checksum, applied_at)
+				VALUES (?, ?, ?, ?)`,
+				&sqlitex.ExecOptions{Args: []any{m.Version, m.Name, m.Checksum(), JulianNow()}},
+			)
+		})); err != nil {
+			return fmt.Errorf("failed to apply migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if err := db.persist(conn); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range slices.Backward(db.versionedMigrations) {
+		if _, ok := applied[m.Version]; !ok || m.Version <= version {
+			continue
+		}
+		if m.Down == "" {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, ErrIrreversibleMigration)
+		}
+		if _, err := writeTX(conn, func(tx *sqlite.Conn) error {
+			if err := sqlitex.ExecuteScript(tx, m.Down, nil); err != nil {
+				return err
+			}
+			return sqlitex.Execute(tx, "DELETE FROM _migrations WHERE version = ?", &sqlitex.ExecOptions{
+				Args: []any{m.Version},
+			})
+		}); err != nil {
+			return fmt.Errorf("failed to roll back migration %d %s: %w", m.Version, m.Name, err)
+		}
+		if err := db.persist(conn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func appliedMigrations(conn *sqlite.Conn) (map[int]appliedMigration, error) {
+	if err := sqlitex.ExecuteTransient(conn, `
+		CREATE TABLE IF NOT EXISTS _migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at REAL NOT NULL
+		)`, nil); err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	applied := map[int]appliedMigration{}
+	if err := sqlitex.ExecuteTransient(conn, "SELECT version, name, checksum, applied_at FROM _migrations", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			applied[stmt.ColumnInt(0)] = appliedMigration{
+				name:      stmt.ColumnText(1),
+				checksum:  stmt.ColumnText(2),
+				appliedAt: JulianDayToTime(stmt.ColumnFloat(3)),
+			}
+			return nil
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func migrationStatus(migrations []Migration, applied map[int]appliedMigration) []MigrationState {
+	status := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = a.appliedAt
+			state.Drifted = a.checksum != m.Checksum()
+		}
+		status = append(status, state)
+	}
+
+	for version, a := range applied {
+		if !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Version == version }) {
+			status = append(status, MigrationState{
+				Version:   version,
+				Name:      a.name,
+				Applied:   true,
+				AppliedAt: a.appliedAt,
+				Unknown:   true,
+			})
+		}
+	}
+	slices.SortFunc(status, func(a, b MigrationState) int {
+		return a.Version - b.Version
+	})
+	return status
+}
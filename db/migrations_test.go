@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+var migrationsTestFS = fstest.MapFS{
+	"migrations/0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+	"migrations/0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"migrations/0002_create_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id));")},
+	"migrations/0002_create_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+	"migrations/0003_index_posts.up.sql":    {Data: []byte("CREATE INDEX posts_user_id ON posts (user_id);")},
+	"migrations/0003_index_posts.down.sql":  {Data: []byte("DROP INDEX posts_user_id;")},
+	"migrations/README.md":                  {Data: []byte("not a migration")},
+}
+
+func tableExists(t *testing.T, db *Database, name string) bool {
+	t.Helper()
+	var exists bool
+	require.NoError(t, db.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "SELECT 1 FROM sqlite_master WHERE name = ?", &sqlitex.ExecOptions{
+			Args: []any{name},
+			ResultFunc: func(*sqlite.Stmt) error {
+				exists = true
+				return nil
+			},
+		})
+	}))
+	return exists
+}
+
+func appliedVersions(t *testing.T, db *Database) []int {
+	t.Helper()
+	status, err := db.MigrationStatus(context.Background())
+	require.NoError(t, err)
+	var versions []int
+	for _, state := range status {
+		if state.Applied {
+			versions = append(versions, state.Version)
+		}
+	}
+	return versions
+}
+
+func TestVersionedMigrationsFromFS(t *testing.T) {
+	migrations, err := VersionedMigrationsFromFS(migrationsTestFS, "migrations")
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "create_users", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE users;", migrations[0].Down)
+	assert.Equal(t, "index_posts", migrations[2].Name)
+}
+
+func TestVersionedMigrationsFromFSBadNames(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"no direction":   {"m/0001_users.sql": {}},
+		"bad direction":  {"m/0001_users.sideways.sql": {}},
+		"no version":     {"m/users.up.sql": {}},
+		"zero version":   {"m/0000_users.up.sql": {}},
+		"missing up":     {"m/0001_users.down.sql": {Data: []byte("DROP TABLE users;")}},
+		"name collision": {"m/0001_users.up.sql": {Data: []byte("SELECT 1;")}, "m/0001_people.down.sql": {}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := VersionedMigrationsFromFS(fsys, "m")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrateToLatestAndBack(t *testing.T) {
+	migrations, err := VersionedMigrationsFromFS(migrationsTestFS, "migrations")
+	require.NoError(t, err)
+	db := newTestDatabase(t, DatabaseWithVersionedMigrations(migrations))
+	ctx := context.Background()
+
+	assert.Equal(t, []int{1, 2, 3}, appliedVersions(t, db))
+	assert.True(t, tableExists(t, db, "posts_user_id"))
+
+	require.NoError(t, db.MigrateTo(ctx, 1))
+	assert.Equal(t, []int{1}, appliedVersions(t, db))
+	assert.True(t, tableExists(t, db, "users"))
+	assert.False(t, tableExists(t, db, "posts"))
+
+	require.NoError(t, db.MigrateTo(ctx, 0))
+	assert.Empty(t, appliedVersions(t, db))
+	assert.False(t, tableExists(t, db, "users"))
+
+	require.NoError(t, db.MigrateTo(ctx, 3))
+	assert.Equal(t, []int{1, 2, 3}, appliedVersions(t, db))
+
+	assert.Error(t, db.MigrateTo(ctx, 4))
+}
+
+func TestMigrateToWithoutDownScript(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithVersionedMigrations([]Migration{
+		{Version: 1, Name: "users", Up: "CREATE TABLE users (id INTEGER PRIMARY KEY);", Down: "DROP TABLE users;"},
+		{Version: 2, Name: "posts", Up: "CREATE TABLE posts (id INTEGER PRIMARY KEY);"},
+	}))
+
+	err := db.MigrateTo(context.Background(), 0)
+	require.ErrorIs(t, err, ErrIrreversibleMigration)
+	assert.Equal(t, []int{1, 2}, appliedVersions(t, db))
+	assert.True(t, tableExists(t, db, "posts"))
+}
+
+func TestMigrationDrift(t *testing.T) {
+	migrations, err := VersionedMigrationsFromFS(migrationsTestFS, "migrations")
+	require.NoError(t, err)
+	db := newTestDatabase(t, DatabaseWithVersionedMigrations(migrations))
+	ctx := context.Background()
+
+	db.versionedMigrations[1].Up = "CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT);"
+
+	status, err := db.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+	assert.False(t, status[0].Drifted)
+	assert.True(t, status[1].Drifted)
+	assert.True(t, status[1].Applied)
+	assert.False(t, status[1].AppliedAt.IsZero())
+
+	assert.ErrorIs(t, db.MigrateTo(ctx, 1), ErrMigrationDrift)
+	assert.Equal(t, []int{1, 2, 3}, appliedVersions(t, db))
+}
+
+func TestUnknownMigration(t *testing.T) {
+	migrations, err := VersionedMigrationsFromFS(migrationsTestFS, "migrations")
+	require.NoError(t, err)
+	db := newTestDatabase(t, DatabaseWithVersionedMigrations(migrations))
+	ctx := context.Background()
+
+	db.versionedMigrations = db.versionedMigrations[:2]
+
+	status, err := db.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+	assert.Equal(t, MigrationState{
+		Version:   3,
+		Name:      "index_posts",
+		Applied:   true,
+		AppliedAt: status[2].AppliedAt,
+		Unknown:   true,
+	}, status[2])
+
+	assert.ErrorIs(t, db.MigrateTo(ctx, 1), ErrUnknownMigration)
+	assert.True(t, tableExists(t, db, "posts"))
+}
+
+func TestVersionedMigrationsMustBeUnique(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewDatabase(ctx, DatabaseInMemory(), DatabaseWithVersionedMigrations([]Migration{
+		{Version: 2, Name: "posts", Up: "CREATE TABLE posts (id INTEGER PRIMARY KEY);"},
+		{Version: 1, Name: "users", Up: "CREATE TABLE users (id INTEGER PRIMARY KEY);"},
+		{Version: 2, Name: "comments", Up: "CREATE TABLE comments (id INTEGER PRIMARY KEY);"},
+	}))
+	assert.ErrorContains(t, err, "migration version 2 is used more than once")
+
+	_, err = NewDatabase(ctx, DatabaseInMemory(), DatabaseWithVersionedMigrations([]Migration{
+		{Version: 0, Name: "users", Up: "CREATE TABLE users (id INTEGER PRIMARY KEY);"},
+	}))
+	assert.ErrorContains(t, err, "must have a positive version")
+}
+
+func TestMigrationStatusAfterClose(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithVersionedMigrations([]Migration{
+		{Version: 1, Name: "users", Up: "CREATE TABLE users (id INTEGER PRIMARY KEY);"},
+	}))
+	require.NoError(t, db.Close())
+
+	_, err := db.MigrationStatus(context.Background())
+	assert.ErrorIs(t, err, ErrDatabaseClosed)
+}
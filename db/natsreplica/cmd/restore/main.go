@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/delaneyj/toolbelt/db/natsreplica"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func main() {
+	var (
+		url    = flag.String("url", nats.DefaultURL, "NATS server URL")
+		bucket = flag.String("bucket", "", "Object store bucket holding the snapshots (required)")
+		name   = flag.String("name", "database.sqlite", "Name the snapshots were stored under")
+		output = flag.String("output", "", "Database file to write (default: -name)")
+		at     = flag.String("at", "", "Restore the newest snapshot taken at or before this RFC 3339 time")
+		list   = flag.Bool("list", false, "List the snapshots instead of restoring")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "restore - rebuild a database from snapshots in a JetStream object store\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -bucket backups -list\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -bucket backups -output data/database.sqlite\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -bucket backups -at 2024-05-01T12:00:00Z\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if *bucket == "" {
+		fmt.Fprintf(os.Stderr, "Error: -bucket flag is required\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *output == "" {
+		*output = *name
+	}
+
+	var t time.Time
+	if *at != "" {
+		var err error
+		if t, err = time.Parse(time.RFC3339, *at); err != nil {
+			log.Fatalf("Failed to parse -at: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	nc, err := nats.Connect(*url)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		log.Fatalf("Failed to create JetStream context: %v", err)
+	}
+	store, err := js.ObjectStore(ctx, *bucket)
+	if err != nil {
+		log.Fatalf("Failed to open object store: %v", err)
+	}
+
+	if *list {
+		snapshots, err := natsreplica.Snapshots(ctx, store, *name)
+		if err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		for _, info := range snapshots {
+			taken, _ := natsreplica.SnapshotTime(info.Name)
+			fmt.Printf("%s\t%d bytes\n", taken.Format(time.RFC3339Nano), info.Size)
+		}
+		return
+	}
+
+	if err := natsreplica.RestoreAt(ctx, store, *name, *output, t); err != nil {
+		log.Fatalf("Failed to restore database: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Restored %s to %s\n", *name, *output)
+}
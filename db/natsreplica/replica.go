@@ -0,0 +1,317 @@
+// Package natsreplica replicates a db.Database into a JetStream object store
+// as periodic snapshots and restores the database file from them.
+package natsreplica
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/delaneyj/toolbelt/db"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// snapshotTimeLayout sorts lexically in time order, so the newest snapshot is
+// the last name.
+const snapshotTimeLayout = "20060102T150405.000000000Z"
+
+// ErrNoSnapshot is returned when there is no snapshot to restore.
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+type options struct {
+	name          string
+	interval      time.Duration
+	retain        int
+	onError       func(error)
+	backupOptions []db.BackupOption
+}
+
+type Option func(*options)
+
+// WithName sets the name snapshots are stored under. It defaults to the base
+// name of the database file.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithInterval sets how often Run takes a snapshot. It defaults to a minute.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithRetain sets how many snapshots are kept, older ones are deleted after
+// each new snapshot. Zero or less keeps every snapshot. It defaults to 24.
+func WithRetain(retain int) Option {
+	return func(o *options) {
+		o.retain = retain
+	}
+}
+
+// WithErrorHandler makes Run report failed snapshots to fn and keep going
+// instead of returning the first error.
+func WithErrorHandler(fn func(error)) Option {
+	return func(o *options) {
+		o.onError = fn
+	}
+}
+
+// WithBackupOptions passes opts to the backups snapshots are taken with.
+func WithBackupOptions(opts ...db.BackupOption) Option {
+	return func(o *options) {
+		o.backupOptions = opts
+	}
+}
+
+// Replicator uploads snapshots of a database to an object store bucket.
+type Replicator struct {
+	db      *db.Database
+	store   jetstream.ObjectStore
+	options options
+	// lastDigest is the digest of the newest snapshot, so unchanged databases
+	// are not uploaded again.
+	lastDigest string
+}
+
+// New creates the bucket if needed and returns a Replicator for database.
+func New(ctx context.Context, js jetstream.JetStream, bucket string, database *db.Database, opts ...Option) (*Replicator, error) {
+	o := options{
+		interval: time.Minute,
+		retain:   24,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.name == "" {
+		o.name = "database.sqlite"
+		if path := database.Path(); path != "" {
+			o.name = filepath.Base(path)
+		}
+	}
+	if o.interval <= 0 {
+		return nil, fmt.Errorf("snapshot interval must be positive, got %s", o.interval)
+	}
+
+	store, err := js.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      bucket,
+		Description: "database snapshots",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object store: %w", err)
+	}
+
+	r := &Replicator{db: database, store: store, options: o}
+	snapshots, err := Snapshots(ctx, store, o.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) > 0 {
+		r.lastDigest = snapshots[len(snapshots)-1].Digest
+	}
+	return r, nil
+}
+
+// Run takes a snapshot immediately and then every interval until ctx is done.
+// Without WithErrorHandler the first failed snapshot stops Run and is
+// returned.
+func (r *Replicator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.options.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Snapshot(ctx); err != nil {
+			if r.options.onError == nil {
+				return err
+			}
+			r.options.onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshot backs up the database and uploads it if it changed since the last
+// snapshot. It returns nil info when nothing was uploaded.
+func (r *Replicator) Snapshot(ctx context.Context) (*jetstream.ObjectInfo, error) {
+	dir, err := os.MkdirTemp("", "snapshot")
+	if err != nil {
+		return nil, fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, r.options.name)
+	if err := r.db.Backup(ctx, path, r.options.backupOptions...); err != nil {
+		return nil, fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("could not hash snapshot: %w", err)
+	}
+	digest := jetstream.GetObjectDigestValue(h)
+	if digest == r.lastDigest {
+		return nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not rewind snapshot: %w", err)
+	}
+
+	info, err := r.store.Put(ctx, jetstream.ObjectMeta{
+		Name:        snapshotName(r.options.name, time.Now()),
+		Description: "snapshot of " + r.options.name,
+	}, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	r.lastDigest = info.Digest
+
+	if err := r.prune(ctx); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+func (r *Replicator) prune(ctx context.Context) error {
+	if r.options.retain <= 0 {
+		return nil
+	}
+	snapshots, err := Snapshots(ctx, r.store, r.options.name)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > r.options.retain {
+		if err := r.store.Delete(ctx, snapshots[0].Name); err != nil {
+			return fmt.Errorf("failed to delete old snapshot: %w", err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+func snapshotName(name string, t time.Time) string {
+	return name + "/" + t.UTC().Format(snapshotTimeLayout)
+}
+
+// SnapshotTime returns when the snapshot with the given object name was taken.
+func SnapshotTime(objectName string) (time.Time, error) {
+	i := strings.LastIndex(objectName, "/")
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("%q is not a snapshot name", objectName)
+	}
+	t, err := time.Parse(snapshotTimeLayout, objectName[i+1:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a snapshot name: %w", objectName, err)
+	}
+	return t, nil
+}
+
+// Snapshots lists the snapshots stored under name, oldest first.
+func Snapshots(ctx context.Context, store jetstream.ObjectStore, name string) ([]*jetstream.ObjectInfo, error) {
+	infos, err := store.List(ctx)
+	if errors.Is(err, jetstream.ErrNoObjectsFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var snapshots []*jetstream.ObjectInfo
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name, name+"/") {
+			continue
+		}
+		if _, err := SnapshotTime(info.Name); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, info)
+	}
+	slices.SortFunc(snapshots, func(a, b *jetstream.ObjectInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snapshots, nil
+}
+
+// Restore writes the newest snapshot stored under name to dstPath. The
+// database at dstPath must not be open.
+func Restore(ctx context.Context, store jetstream.ObjectStore, name, dstPath string) error {
+	return RestoreAt(ctx, store, name, dstPath, time.Time{})
+}
+
+// RestoreAt writes the newest snapshot stored under name that was taken at or
+// before t to dstPath. A zero t restores the newest snapshot. The database at
+// dstPath must not be open; its WAL and shared memory files are removed so
+// they are not replayed over the restored database.
+func RestoreAt(ctx context.Context, store jetstream.ObjectStore, name, dstPath string, t time.Time) (err error) {
+	snapshots, err := Snapshots(ctx, store, name)
+	if err != nil {
+		return err
+	}
+	if !t.IsZero() {
+		cutoff := snapshotName(name, t)
+		snapshots = slices.DeleteFunc(snapshots, func(info *jetstream.ObjectInfo) bool {
+			return info.Name > cutoff
+		})
+	}
+	if len(snapshots) == 0 {
+		return ErrNoSnapshot
+	}
+	snapshot := snapshots[len(snapshots)-1]
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return fmt.Errorf("could not create database directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create database file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	result, err := store.Get(ctx, snapshot.Name)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	defer result.Close()
+
+	if _, err := io.Copy(tmp, result); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write database file: %w", err)
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dstPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove stale database file: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		return fmt.Errorf("could not move database into place: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package natsreplica
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/delaneyj/toolbelt/db"
+	"github.com/delaneyj/toolbelt/embeddednats"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func newJetStream(t *testing.T) jetstream.JetStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ns, err := embeddednats.New(ctx, embeddednats.WithNATSServerOptions(&server.Options{
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		Port:      server.RANDOM_PORT,
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { ns.Close() })
+	ns.WaitForServer()
+
+	nc, err := ns.Client()
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	return js
+}
+
+func newDatabase(t *testing.T, path string) *db.Database {
+	t.Helper()
+	database, err := db.NewDatabase(context.Background(),
+		db.DatabaseWithFilename(path),
+		db.DatabaseWithMigrations([]string{"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)"}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func addNote(t *testing.T, database *db.Database, body string) {
+	t.Helper()
+	require.NoError(t, database.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO notes (body) VALUES (?)", &sqlitex.ExecOptions{Args: []any{body}})
+	}))
+}
+
+func noteCount(t *testing.T, path string) int {
+	t.Helper()
+	database := newDatabase(t, path)
+	var n int
+	require.NoError(t, database.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "SELECT COUNT(*) FROM notes", &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				n = stmt.ColumnInt(0)
+				return nil
+			},
+		})
+	}))
+	return n
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	js := newJetStream(t)
+	database := newDatabase(t, filepath.Join(t.TempDir(), "app.sqlite"))
+
+	r, err := New(ctx, js, "snapshots", database, WithRetain(2))
+	require.NoError(t, err)
+
+	var taken []*jetstream.ObjectInfo
+	for i := range 3 {
+		addNote(t, database, "note")
+		info, err := r.Snapshot(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, info, "snapshot %d", i)
+		taken = append(taken, info)
+
+		// An unchanged database is not uploaded again.
+		info, err = r.Snapshot(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	}
+
+	// Only the newest snapshots are retained.
+	snapshots, err := Snapshots(ctx, r.store, "app.sqlite")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, taken[1].Name, snapshots[0].Name)
+	assert.Equal(t, taken[2].Name, snapshots[1].Name)
+
+	// A new replicator picks up the digest of the newest snapshot.
+	r, err = New(ctx, js, "snapshots", database, WithRetain(2))
+	require.NoError(t, err)
+	info, err := r.Snapshot(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, info)
+
+	// Restoring replaces the database and drops its stale WAL files.
+	dst := filepath.Join(t.TempDir(), "restored", "app.sqlite")
+	require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+	for _, suffix := range []string{"-wal", "-shm"} {
+		require.NoError(t, os.WriteFile(dst+suffix, []byte("stale"), 0o600))
+	}
+
+	second, err := SnapshotTime(taken[1].Name)
+	require.NoError(t, err)
+	require.NoError(t, RestoreAt(ctx, r.store, "app.sqlite", dst, second))
+	for _, suffix := range []string{"-wal", "-shm"} {
+		assert.NoFileExists(t, dst+suffix)
+	}
+	assert.Equal(t, 2, noteCount(t, dst))
+
+	latest := filepath.Join(t.TempDir(), "app.sqlite")
+	require.NoError(t, Restore(ctx, r.store, "app.sqlite", latest))
+	assert.Equal(t, 3, noteCount(t, latest))
+
+	// The first snapshot was pruned, so there is nothing that old.
+	first, err := SnapshotTime(taken[0].Name)
+	require.NoError(t, err)
+	assert.ErrorIs(t, RestoreAt(ctx, r.store, "app.sqlite", latest, first), ErrNoSnapshot)
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"zombiezen.com/go/sqlite"
+)
+
+// RetryPolicy controls how WriteTX and ReadTX retry transactions that fail
+// because the database is busy or locked. The whole transaction function is
+// run again, so it must be safe to repeat. Connections already wait for a
+// lock held by another connection until the context is done, so retries
+// cover the busy errors SQLite returns without waiting, and give up with the
+// context otherwise. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries.
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after each retry; values below 1 keep it
+	// constant.
+	Multiplier float64
+}
+
+// DefaultRetryPolicy retries a busy transaction for roughly a second.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    8,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     250 * time.Millisecond,
+	Multiplier:     2,
+}
+
+// DatabaseWithRetryPolicy sets the retry policy for busy transactions, see
+// DefaultRetryPolicy.
+func DatabaseWithRetryPolicy(policy RetryPolicy) DatabaseOption {
+	return func(o *databaseOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// IsBusy reports whether err was caused by SQLITE_BUSY or SQLITE_LOCKED.
+func IsBusy(err error) bool {
+	switch sqlite.ErrCode(err).ToPrimary() {
+	case sqlite.ResultBusy, sqlite.ResultLocked:
+		return true
+	default:
+		return false
+	}
+}
+
+type txLabelKey struct{}
+
+// WithTxLabel names the transactions run with ctx. The label is added to the
+// slog records the database writes through toolbelt.CtxSlog.
+func WithTxLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, txLabelKey{}, label)
+}
+
+// TxLabel returns the label set by WithTxLabel, or "" if there is none.
+func TxLabel(ctx context.Context) string {
+	label, _ := ctx.Value(txLabelKey{}).(string)
+	return label
+}
+
+func txLogAttrs(ctx context.Context, mode string) []any {
+	attrs := []any{slog.String("tx_mode", mode)}
+	if label := TxLabel(ctx); label != "" {
+		attrs = append(attrs, slog.String("tx", label))
+	}
+	return attrs
+}
+
+// retry runs attempt until it succeeds, fails with an error other than
+// IsBusy, or the retry policy is exhausted.
+func (db *Database) retry(ctx context.Context, mode string, attempt func() error) error {
+	metrics := db.readMetrics
+	if mode == "write" {
+		metrics = db.writeMetrics
+	}
+
+	policy := db.retryPolicy
+	backoff := policy.InitialBackoff
+	logger, hasLogger := toolbelt.CtxSlog(ctx)
+
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || !IsBusy(err) {
+			return err
+		}
+		if n >= policy.MaxAttempts {
+			if hasLogger && n > 1 {
+				logger.WarnContext(ctx, "busy transaction failed after retries",
+					append(txLogAttrs(ctx, mode), slog.Int("attempts", n), slog.Any("error", err))...)
+			}
+			return err
+		}
+
+		metrics.retries.Add(1)
+		if hasLogger {
+			logger.DebugContext(ctx, "retrying busy transaction",
+				append(txLogAttrs(ctx, mode), slog.Int("attempt", n), slog.Duration("backoff", backoff), slog.Any("error", err))...)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if policy.Multiplier > 1 {
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		}
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+var retryTestPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     2 * time.Millisecond,
+	Multiplier:     2,
+}
+
+// busyFor returns a TxFn failing with SQLITE_BUSY for the first failures
+// calls, and the number of calls made so far.
+func busyFor(failures int) (TxFn, *int) {
+	calls := 0
+	return func(*sqlite.Conn) error {
+		calls++
+		if calls <= failures {
+			return sqlite.ResultBusy.ToError()
+		}
+		return nil
+	}, &calls
+}
+
+func TestRetryBusy(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithRetryPolicy(retryTestPolicy))
+	ctx := context.Background()
+
+	fn, calls := busyFor(2)
+	require.NoError(t, db.WriteTX(ctx, fn))
+	assert.Equal(t, 3, *calls)
+
+	fn, calls = busyFor(1)
+	require.NoError(t, db.ReadTX(ctx, fn))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithRetryPolicy(retryTestPolicy))
+
+	fn, calls := busyFor(10)
+	err := db.WriteTX(context.Background(), fn)
+	require.Error(t, err)
+	assert.True(t, IsBusy(err))
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetrySkipsOtherErrors(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithRetryPolicy(retryTestPolicy))
+
+	errBoom := errors.New("boom")
+	calls := 0
+	err := db.WriteTX(context.Background(), func(*sqlite.Conn) error {
+		calls++
+		return errBoom
+	})
+	require.ErrorIs(t, err, errBoom)
+	assert.False(t, IsBusy(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryZeroPolicy(t *testing.T) {
+	db := newTestDatabase(t)
+
+	fn, calls := busyFor(1)
+	assert.True(t, IsBusy(db.WriteTX(context.Background(), fn)))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithRetryPolicy(RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Hour,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	fn, calls := busyFor(10)
+	assert.True(t, IsBusy(db.WriteTX(ctx, fn)))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestRetryRealContention(t *testing.T) {
+	db := newTestDatabase(t,
+		DatabaseWithMigrations([]string{"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)"}),
+		DatabaseWithRetryPolicy(retryTestPolicy),
+	)
+
+	// A second connection, as another process would have, holds the write
+	// lock.
+	other, err := sqlite.OpenConn(db.Path())
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, sqlitex.ExecuteTransient(other, "BEGIN IMMEDIATE", nil))
+
+	insert := func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO notes (body) VALUES ('hello')", nil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = db.WriteTX(ctx, insert)
+	require.Error(t, err)
+	assert.True(t, IsBusy(err), "got %v", err)
+
+	// Once the lock is released, a waiting write goes through.
+	released := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		released <- sqlitex.ExecuteTransient(other, "COMMIT", nil)
+	}()
+	require.NoError(t, db.WriteTX(context.Background(), insert))
+	require.NoError(t, <-released)
+	assert.Equal(t, 1, countRows(t, db, "notes"))
+}
+
+// readNoteBody reads the body of note 1 through session.
+func readNoteBody(t *testing.T, session *Session) (body string) {
+	t.Helper()
+	require.NoError(t, session.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "SELECT body FROM notes WHERE id = 1", &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				body = stmt.ColumnText(0)
+				return nil
+			},
+		})
+	}))
+	return body
+}
+
+func TestSessionReadsItsOwnWrites(t *testing.T) {
+	db := newTestDatabase(t, DatabaseWithMigrations([]string{
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)",
+	}))
+	ctx := context.Background()
+	session := db.Session()
+	assert.Empty(t, readNoteBody(t, session))
+
+	for _, body := range []string{"first", "second", "third"} {
+		require.NoError(t, session.WriteTX(ctx, func(tx *sqlite.Conn) error {
+			return sqlitex.Execute(tx, "INSERT OR REPLACE INTO notes (id, body) VALUES (1, ?)", &sqlitex.ExecOptions{
+				Args: []any{body},
+			})
+		}))
+		assert.Equal(t, body, readNoteBody(t, session))
+	}
+}
+
+func TestSessionWroteWhenChangesAreNotDelivered(t *testing.T) {
+	bus := toolbelt.NewEventBusSync[ChangeSet]()
+	bus.Subscribe(context.Background(), func(ChangeSet) error { return errors.New("subscriber down") })
+	db := newTestDatabase(t,
+		DatabaseWithMigrations([]string{"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)"}),
+		DatabaseWithChanges(bus),
+	)
+	session := db.Session()
+
+	err := session.WriteTX(context.Background(), func(tx *sqlite.Conn) error {
+		return sqlitex.Execute(tx, "INSERT INTO notes (id, body) VALUES (1, 'hello')", nil)
+	})
+	require.ErrorContains(t, err, "transaction committed")
+
+	// The write committed, so the session reads it on the write connection.
+	reads := db.Stats().Read.Transactions
+	assert.Equal(t, "hello", readNoteBody(t, session))
+	assert.Equal(t, reads, db.Stats().Read.Transactions)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// ScanStruct reads the current row of stmt into a new T, which must be a
+// struct. Columns are matched to exported fields by their `db` tag, or by the
+// snake case of the field name when there is no tag; a tag of "-" skips the
+// field and columns without a field are ignored. Fields of embedded structs
+// are matched as if they were declared on T, except through embedded
+// pointers.
+//
+// time.Time and *timestamppb.Timestamp fields are read from Julian days and
+// time.Duration fields from milliseconds, matching TimeToJulianDay and
+// DurationToMilliseconds. NULL leaves a field at its zero value; pointer
+// fields are set only for non-NULL columns.
+func ScanStruct[T any](stmt *sqlite.Stmt) (T, error) {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() != reflect.Struct {
+		return v, fmt.Errorf("could not scan into %s, it is not a struct", rv.Type())
+	}
+
+	fields := structFieldsOf(rv.Type())
+	for col := range stmt.ColumnCount() {
+		index, ok := fields[stmt.ColumnName(col)]
+		if !ok || stmt.ColumnIsNull(col) {
+			continue
+		}
+		if err := scanColumn(stmt, col, rv.FieldByIndex(index)); err != nil {
+			return v, fmt.Errorf("could not scan column %q: %w", stmt.ColumnName(col), err)
+		}
+	}
+	return v, nil
+}
+
+// QueryAll runs query with args on conn and scans every row into a T with
+// ScanStruct.
+func QueryAll[T any](conn *sqlite.Conn, query string, args ...any) ([]T, error) {
+	var rows []T
+	if err := sqlitex.Execute(conn, query, &sqlitex.ExecOptions{
+		Args: args,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			row, err := ScanStruct[T](stmt)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+			return nil
+		},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to query rows: %w", err)
+	}
+	return rows, nil
+}
+
+var structFieldsCache sync.Map // reflect.Type -> map[string][]int
+
+// structFieldsOf maps column names to field indexes for t.
+func structFieldsOf(t reflect.Type) map[string][]int {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+
+	fields := map[string][]int{}
+	var pointerEmbeds [][]int
+	for _, f := range reflect.VisibleFields(t) {
+		if slices.ContainsFunc(pointerEmbeds, func(prefix []int) bool { return hasIndexPrefix(f.Index, prefix) }) {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Pointer {
+			pointerEmbeds = append(pointerEmbeds, f.Index)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = toolbelt.Snake(f.Name)
+		}
+		// When two fields map to the same column the first one declared wins.
+		if _, ok := fields[name]; !ok {
+			fields[name] = f.Index
+		}
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// hasIndexPrefix reports whether the field at index is promoted through the
+// embedded field at prefix.
+func hasIndexPrefix(index, prefix []int) bool {
+	return len(index) > len(prefix) && slices.Equal(index[:len(prefix)], prefix)
+}
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	durationType  = reflect.TypeFor[time.Duration]()
+	timestampType = reflect.TypeFor[*timestamppb.Timestamp]()
+	bytesType     = reflect.TypeFor[[]byte]()
+)
+
+func scanColumn(stmt *sqlite.Stmt, col int, field reflect.Value) error {
+	switch field.Type() {
+	case timeType:
+		field.Set(reflect.ValueOf(JulianDayToTime(stmt.ColumnFloat(col))))
+		return nil
+	case durationType:
+		field.SetInt(int64(MillisecondsToDuration(stmt.ColumnInt64(col))))
+		return nil
+	case timestampType:
+		field.Set(reflect.ValueOf(JulianDayToTimestamp(stmt.ColumnFloat(col))))
+		return nil
+	case bytesType:
+		field.SetBytes(StmtBytesByCol(stmt, col))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := scanColumn(stmt, col, elem.Elem()); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.String:
+		field.SetString(stmt.ColumnText(col))
+	case reflect.Bool:
+		field.SetBool(stmt.ColumnBool(col))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		field.SetInt(stmt.ColumnInt64(col))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		field.SetUint(uint64(stmt.ColumnInt64(col)))
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(stmt.ColumnFloat(col))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
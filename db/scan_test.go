@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"zombiezen.com/go/sqlite"
+)
+
+type scanAudit struct {
+	CreatedBy string
+	Revision  int
+}
+
+type scanExtra struct {
+	Note string
+}
+
+type scanUser struct {
+	scanAudit
+	*scanExtra
+
+	ID        int64  `db:"user_id"`
+	FullName  string `db:"name,omitempty"`
+	Email     *string
+	Nickname  *string
+	Secret    string `db:"-"`
+	Score     float64
+	Active    bool
+	Data      []byte
+	JoinedAt  time.Time
+	SeenAt    *timestamppb.Timestamp
+	Timeout   time.Duration
+	unexposed string
+}
+
+func queryAllInMemory[T any](t *testing.T, query string, args ...any) []T {
+	t.Helper()
+	db := newTestDatabase(t, DatabaseInMemory())
+
+	var rows []T
+	require.NoError(t, db.ReadTX(context.Background(), func(tx *sqlite.Conn) (err error) {
+		rows, err = QueryAll[T](tx, query, args...)
+		return err
+	}))
+	return rows
+}
+
+func TestQueryAll(t *testing.T) {
+	joined := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	seen := joined.Add(36 * time.Hour)
+
+	rows := queryAllInMemory[scanUser](t, `
+		SELECT
+			7 AS user_id, 'Ada Lovelace' AS name, 'ada@example.com' AS email, NULL AS nickname,
+			'hidden' AS secret, 'ignored' AS unexposed, 'no field' AS extra_column,
+			1.5 AS score, 1 AS active, x'0102' AS data,
+			? AS joined_at, ? AS seen_at, 1500 AS timeout,
+			'admin' AS created_by, 3 AS revision, 'not scanned' AS note
+		UNION ALL
+		SELECT 8, 'Grace Hopper', NULL, 'amazing grace', NULL, NULL, NULL, NULL, 0, NULL, NULL, NULL, NULL, NULL, NULL, NULL`,
+		TimeToJulianDay(joined), TimeToJulianDay(seen),
+	)
+	require.Len(t, rows, 2)
+
+	ada := rows[0]
+	assert.Equal(t, int64(7), ada.ID)
+	assert.Equal(t, "Ada Lovelace", ada.FullName)
+	require.NotNil(t, ada.Email)
+	assert.Equal(t, "ada@example.com", *ada.Email)
+	assert.Nil(t, ada.Nickname)
+	assert.Empty(t, ada.Secret)
+	assert.Empty(t, ada.unexposed)
+	assert.Equal(t, 1.5, ada.Score)
+	assert.True(t, ada.Active)
+	assert.Equal(t, []byte{1, 2}, ada.Data)
+	assert.WithinDuration(t, joined, ada.JoinedAt, time.Second)
+	require.NotNil(t, ada.SeenAt)
+	assert.WithinDuration(t, seen, ada.SeenAt.AsTime(), time.Second)
+	assert.Equal(t, 1500*time.Millisecond, ada.Timeout)
+	// Embedded structs are flattened, embedded pointers are skipped.
+	assert.Equal(t, scanAudit{CreatedBy: "admin", Revision: 3}, ada.scanAudit)
+	assert.Nil(t, ada.scanExtra)
+
+	grace := rows[1]
+	assert.Equal(t, int64(8), grace.ID)
+	assert.Nil(t, grace.Email)
+	require.NotNil(t, grace.Nickname)
+	assert.Equal(t, "amazing grace", *grace.Nickname)
+	assert.Zero(t, grace.Score)
+	assert.Nil(t, grace.Data)
+	assert.True(t, grace.JoinedAt.IsZero())
+	assert.Nil(t, grace.SeenAt)
+	assert.Zero(t, grace.Timeout)
+	assert.Zero(t, grace.scanAudit)
+}
+
+func TestScanStructNotAStruct(t *testing.T) {
+	db := newTestDatabase(t, DatabaseInMemory())
+
+	err := db.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		_, err := QueryAll[int](tx, "SELECT 1")
+		return err
+	})
+	assert.Error(t, err)
+}
+
+func TestScanStructUnsupportedField(t *testing.T) {
+	type withMap struct {
+		Tags map[string]string
+	}
+	db := newTestDatabase(t, DatabaseInMemory())
+
+	err := db.ReadTX(context.Background(), func(tx *sqlite.Conn) error {
+		_, err := QueryAll[withMap](tx, "SELECT 'x' AS tags")
+		return err
+	})
+	assert.ErrorContains(t, err, `could not scan column "tags"`)
+}
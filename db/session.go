@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Session groups the transactions of one logical client so that reads always
+// observe the session's own earlier writes. Until the session writes, reads
+// use the read pool as usual; afterwards they run on the write connection,
+// which sees its own commits whatever the journal mode or pool setup. Reads
+// on the write connection wait for other writers, so keep sessions short.
+type Session struct {
+	db    *Database
+	wrote atomic.Bool
+}
+
+// Session starts a read-your-writes session.
+func (db *Database) Session() *Session {
+	return &Session{db: db}
+}
+
+// WriteTX runs fn like Database.WriteTX and marks the session as having
+// written once the transaction commits, even if its changes could not be
+// emitted afterwards.
+func (s *Session) WriteTX(ctx context.Context, fn TxFn) error {
+	return s.db.writeTXThen(ctx, fn, func() { s.wrote.Store(true) })
+}
+
+// ReadTX runs fn in a read transaction that sees every write the session has
+// committed.
+func (s *Session) ReadTX(ctx context.Context, fn TxFn) error {
+	if !s.wrote.Load() {
+		return s.db.ReadTX(ctx, fn)
+	}
+	return s.db.retry(ctx, "read", func() error {
+		return s.db.readTX(ctx, s.db.writePool, s.db.writeMetrics, fn)
+	})
+}
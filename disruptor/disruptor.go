@@ -1,6 +1,11 @@
 package disruptor
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
 
 // SingleProducer exposes the writer and reader coordination primitives for a
 // single-writer disruptor. It owns the typed ring buffer used to exchange
@@ -24,9 +29,14 @@ type Producer[T any] interface {
 	PublishBatch(count uint64, write func(lower, upper uint64, ring []T, mask uint64)) (uint64, uint64)
 	Reserve(count uint64) uint64
 	ReserveRange(count uint64) (uint64, uint64)
+	TryReserve(count uint64) (uint64, bool)
+	TryReserveRange(count uint64) (uint64, uint64, bool)
+	ReserveCtx(ctx context.Context, count uint64) (uint64, error)
+	ReserveRangeCtx(ctx context.Context, count uint64) (uint64, uint64, error)
 	Commit(lower, upper uint64)
 	Read()
 	Close() error
+	Stats() Stats
 }
 
 // NewSingleProducer constructs a single-writer disruptor and panics if
@@ -74,6 +84,8 @@ func NewMultiProducerDisruptor[T any](options ...any) (MultiProducer[T], error)
 // that all methods are promoted automatically.
 type baseDisruptor[T any] struct {
 	reader    reader
+	readers   []reader
+	cursor    *cursor
 	ring      []T
 	mask      uint64
 	sequencer sequencer
@@ -90,8 +102,12 @@ func buildDisruptor[T any](multi bool, options ...any) (*baseDisruptor[T], error
 			if opt.value != nil {
 				cfg.waitStrategy = opt.value
 			}
+		case exceptionHandlerOption:
+			cfg.exceptionHandler = opt.value
 		case consumerGroupOption[T]:
 			cfg.consumerGroups = append(cfg.consumerGroups, opt.consumers)
+		case consumerOption[T]:
+			cfg.consumers = append(cfg.consumers, opt)
 		case nil:
 			// ignore
 		default:
@@ -118,6 +134,8 @@ func buildDisruptor[T any](multi bool, options ...any) (*baseDisruptor[T], error
 
 	return &baseDisruptor[T]{
 		reader:    newCompositeReader(readers),
+		readers:   readers,
+		cursor:    cursor,
 		ring:      ring,
 		mask:      mask,
 		sequencer: seq,
@@ -128,18 +146,51 @@ func buildDisruptor[T any](multi bool, options ...any) (*baseDisruptor[T], error
 func buildReaders[T any](cfg *config[T], writerSequence *cursor, ring []T, mask uint64) ([]reader, barrier) {
 	upstream := barrier(writerSequence)
 	var readers []reader
+	var leaves []*cursor
+	shared := &readerShared{waiter: cfg.waitStrategy, handler: cfg.exceptionHandler}
 
-	for _, consumerGroup := range cfg.consumerGroups {
+	for g, consumerGroup := range cfg.consumerGroups {
 		groupSequences := make([]*cursor, 0, len(consumerGroup))
-		for _, consumer := range consumerGroup {
+		for c, consumer := range consumerGroup {
 			current := newCursor()
-			reader := newDefaultReader(current, writerSequence, upstream, cfg.waitStrategy, consumer, ring, mask)
+			name := fmt.Sprintf("group %d consumer %d", g, c)
+			reader := newDefaultReader(name, shared, current, writerSequence, upstream, consumer, ring, mask)
 			readers = append(readers, reader)
 			groupSequences = append(groupSequences, current)
 		}
 		upstream = newCompositeBarrier(groupSequences...)
+		leaves = groupSequences
+	}
+
+	// Named consumers are wired up as a dependency graph. Every cursor is
+	// created first so each reader can gate on the consumers it depends on,
+	// and the writer gates on the consumers nothing depends on.
+	sequences := make(map[string]*cursor, len(cfg.consumers))
+	dependedOn := make(map[string]bool, len(cfg.consumers))
+	for _, c := range cfg.consumers {
+		sequences[c.name] = newCursor()
+		for _, dep := range c.dependsOn {
+			dependedOn[dep] = true
+		}
+	}
+	for _, c := range cfg.consumers {
+		consumerUpstream := barrier(writerSequence)
+		if len(c.dependsOn) > 0 {
+			deps := make([]*cursor, len(c.dependsOn))
+			for i, dep := range c.dependsOn {
+				deps[i] = sequences[dep]
+			}
+			consumerUpstream = newCompositeBarrier(deps...)
+		}
+		readers = append(readers, newDefaultReader(c.name, shared, sequences[c.name], writerSequence, consumerUpstream, c.consumer, ring, mask))
+		if !dependedOn[c.name] {
+			leaves = append(leaves, sequences[c.name])
+		}
 	}
 
+	if len(cfg.consumers) > 0 {
+		upstream = newCompositeBarrier(leaves...)
+	}
 	return readers, upstream
 }
 
@@ -187,6 +238,75 @@ func (d *baseDisruptor[T]) Reserve(count uint64) uint64 {
 // ReserveRange reserves count entries and returns the inclusive lower/upper
 // sequence numbers for the reservation.
 func (d *baseDisruptor[T]) ReserveRange(count uint64) (uint64, uint64) {
+	d.checkReservation(count)
+	lower, upper := d.sequencer.next(int64(count), d.upstream)
+	return uint64(lower), uint64(upper)
+}
+
+// TryReserve is Reserve without waiting. It reports false when the
+// consumers have not freed enough space yet.
+func (d *baseDisruptor[T]) TryReserve(count uint64) (uint64, bool) {
+	_, upper, ok := d.TryReserveRange(count)
+	return upper, ok
+}
+
+// TryReserveRange is ReserveRange without waiting. It reports false when the
+// consumers have not freed enough space yet.
+func (d *baseDisruptor[T]) TryReserveRange(count uint64) (uint64, uint64, bool) {
+	d.checkReservation(count)
+	lower, upper, ok := d.sequencer.tryNext(int64(count), d.upstream)
+	return uint64(lower), uint64(upper), ok
+}
+
+// ReserveCtx is Reserve that gives up with the context's error once ctx is
+// done.
+func (d *baseDisruptor[T]) ReserveCtx(ctx context.Context, count uint64) (uint64, error) {
+	_, upper, err := d.ReserveRangeCtx(ctx, count)
+	return upper, err
+}
+
+// Backoff of ReserveRangeCtx: it yields for the first attempts, then sleeps
+// for twice as long after each one up to the maximum.
+const (
+	reserveSpins      = 64
+	reserveMinBackoff = time.Microsecond
+	reserveMaxBackoff = time.Millisecond
+)
+
+// ReserveRangeCtx is ReserveRange that gives up with the context's error
+// once ctx is done. While the ring is full it backs off between attempts, so
+// waiting for slow consumers does not take a core away from them.
+func (d *baseDisruptor[T]) ReserveRangeCtx(ctx context.Context, count uint64) (uint64, uint64, error) {
+	backoff := reserveMinBackoff
+	var timer *time.Timer
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		if lower, upper, ok := d.TryReserveRange(count); ok {
+			return lower, upper, nil
+		}
+
+		if attempt < reserveSpins {
+			runtime.Gosched()
+			continue
+		}
+		if timer == nil {
+			timer = time.NewTimer(backoff)
+			defer timer.Stop()
+		} else {
+			timer.Reset(backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, reserveMaxBackoff)
+	}
+}
+
+func (d *baseDisruptor[T]) checkReservation(count uint64) {
 	if count == 0 {
 		panic(ErrMinimumReservationSize)
 	}
@@ -194,8 +314,6 @@ func (d *baseDisruptor[T]) ReserveRange(count uint64) (uint64, uint64) {
 	if count > capacity {
 		panic(fmt.Errorf("reserve count %d exceeds capacity %d", count, capacity))
 	}
-	lower, upper := d.sequencer.next(int64(count), d.upstream)
-	return uint64(lower), uint64(upper)
 }
 
 // Commit publishes the reserved range to readers.
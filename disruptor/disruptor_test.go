@@ -1,9 +1,18 @@
 package disruptor_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/delaneyj/toolbelt/disruptor"
 )
@@ -91,3 +100,588 @@ func TestMultiProducerSequencer(t *testing.T) {
 		}
 	}
 }
+
+type markingConsumer struct {
+	seen []atomic.Bool
+}
+
+func (c *markingConsumer) Consume(events *disruptor.Events[uint64]) {
+	for _, v := range events.Range() {
+		c.seen[*v].Store(true)
+	}
+}
+
+func TestConsumerDependencyGraph(t *testing.T) {
+	const events = 10000
+
+	journal := &markingConsumer{seen: make([]atomic.Bool, events)}
+	replica := &markingConsumer{seen: make([]atomic.Bool, events)}
+	var early atomic.Int64
+	business := &collectingConsumer{}
+	logic := disruptor.ConsumerFunc[uint64](func(e *disruptor.Events[uint64]) {
+		for _, v := range e.Range() {
+			if !journal.seen[*v].Load() || !replica.seen[*v].Load() {
+				early.Add(1)
+			}
+		}
+		business.Consume(e)
+	})
+
+	d := disruptor.NewSingleProducer[uint64](
+		disruptor.WithCapacity(64),
+		disruptor.WithConsumer[uint64]("journal", journal),
+		disruptor.WithConsumer[uint64]("replica", replica),
+		disruptor.WithConsumer[uint64]("business", logic).DependsOn("journal", "replica"),
+	)
+
+	var readerWG sync.WaitGroup
+	readerWG.Add(1)
+	go func() {
+		defer readerWG.Done()
+		d.Read()
+	}()
+
+	for n := uint64(0); n < events; n++ {
+		d.Publish(func(slot *uint64) {
+			*slot = n
+		})
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatalf("close disruptor: %v", err)
+	}
+	readerWG.Wait()
+
+	if n := early.Load(); n != 0 {
+		t.Fatalf("business consumer saw %d events before its dependencies", n)
+	}
+	values := business.Values()
+	if len(values) != events {
+		t.Fatalf("expected %d values, got %d", events, len(values))
+	}
+	for i, v := range values {
+		if v != uint64(i) {
+			t.Fatalf("expected value %d at index %d, got %d", i, i, v)
+		}
+	}
+}
+
+func TestConsumerDependencyGraphValidation(t *testing.T) {
+	noop := disruptor.ConsumerFunc[uint64](func(*disruptor.Events[uint64]) {})
+
+	cases := map[string][]any{
+		"unknown dependency": {
+			disruptor.WithConsumer[uint64]("a", noop).DependsOn("missing"),
+		},
+		"duplicate name": {
+			disruptor.WithConsumer[uint64]("a", noop),
+			disruptor.WithConsumer[uint64]("a", noop),
+		},
+		"cycle": {
+			disruptor.WithConsumer[uint64]("a", noop).DependsOn("b"),
+			disruptor.WithConsumer[uint64]("b", noop).DependsOn("a"),
+		},
+	}
+	for name, options := range cases {
+		t.Run(name, func(t *testing.T) {
+			options = append(options, disruptor.WithCapacity(8))
+			if _, err := disruptor.NewSingleProducerDisruptor[uint64](options...); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestTryReserveAndReserveCtx(t *testing.T) {
+	const capacity = 8
+
+	release := make(chan struct{})
+	blocking := disruptor.ConsumerFunc[uint64](func(*disruptor.Events[uint64]) {
+		<-release
+	})
+
+	for name, d := range map[string]disruptor.Producer[uint64]{
+		"single": disruptor.NewSingleProducer[uint64](disruptor.WithCapacity(capacity), disruptor.WithConsumerGroup[uint64](blocking)),
+		"multi":  disruptor.NewMultiProducer[uint64](disruptor.WithCapacity(capacity), disruptor.WithConsumerGroup[uint64](blocking)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			release = make(chan struct{})
+
+			var readerWG sync.WaitGroup
+			readerWG.Add(1)
+			go func() {
+				defer readerWG.Done()
+				d.Read()
+			}()
+
+			lower, upper, ok := d.TryReserveRange(capacity)
+			if !ok || lower != 0 || upper != capacity-1 {
+				t.Fatalf("expected to reserve the whole ring, got %d-%d %v", lower, upper, ok)
+			}
+			d.Commit(lower, upper)
+
+			if _, ok := d.TryReserve(1); ok {
+				t.Fatal("expected TryReserve to fail on a full ring")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, err := d.ReserveCtx(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected deadline exceeded, got %v", err)
+			}
+
+			close(release)
+			seq, err := d.ReserveCtx(context.Background(), 1)
+			if err != nil || seq != capacity {
+				t.Fatalf("expected sequence %d, got %d %v", capacity, seq, err)
+			}
+			d.Commit(seq, seq)
+
+			if err := d.Close(); err != nil {
+				t.Fatalf("close disruptor: %v", err)
+			}
+			readerWG.Wait()
+		})
+	}
+}
+
+// countingContext counts how often Err is called, which ReserveRangeCtx does
+// once per attempt.
+type countingContext struct {
+	context.Context
+	calls atomic.Int64
+}
+
+func (c *countingContext) Err() error {
+	c.calls.Add(1)
+	return c.Context.Err()
+}
+
+func TestReserveCtxBacksOff(t *testing.T) {
+	release := make(chan struct{})
+	d := disruptor.NewMultiProducer[uint64](
+		disruptor.WithCapacity(4),
+		disruptor.WithConsumerGroup[uint64](disruptor.ConsumerFunc[uint64](func(*disruptor.Events[uint64]) {
+			<-release
+		})),
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Read()
+	}()
+
+	lower, upper, ok := d.TryReserveRange(4)
+	if !ok {
+		t.Fatal("expected to reserve the whole ring")
+	}
+	d.Commit(lower, upper)
+
+	timeout, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx := &countingContext{Context: timeout}
+	start := time.Now()
+	if _, err := d.ReserveCtx(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected ReserveCtx to give up at the deadline, took %s", elapsed)
+	}
+	// Spinning for 50ms would make millions of attempts, backing off up to a
+	// millisecond makes a few hundred at most.
+	if calls := ctx.calls.Load(); calls > 1000 {
+		t.Fatalf("expected ReserveCtx to back off, made %d attempts", calls)
+	}
+
+	// A waiting reservation still completes once space frees up.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	seq, err := d.ReserveCtx(context.Background(), 1)
+	if err != nil || seq != 4 {
+		t.Fatalf("expected sequence 4, got %d %v", seq, err)
+	}
+	d.Commit(seq, seq)
+
+	if err := d.Close(); err != nil {
+		t.Fatalf("close disruptor: %v", err)
+	}
+	<-done
+}
+
+func TestExceptionHandler(t *testing.T) {
+	run := func(t *testing.T, handler disruptor.ExceptionHandler, consumer disruptor.Consumer[uint64], events uint64) {
+		t.Helper()
+		d := disruptor.NewSingleProducer[uint64](
+			disruptor.WithCapacity(16),
+			disruptor.WithExceptionHandler(handler),
+			disruptor.WithConsumer[uint64]("worker", consumer),
+		)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.Read()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		for n := uint64(0); n < events; n++ {
+			seq, err := d.ReserveCtx(ctx, 1)
+			if err != nil {
+				break
+			}
+			*d.Entry(seq) = n
+			d.Commit(seq, seq)
+		}
+
+		if err := d.Close(); err != nil {
+			t.Fatalf("close disruptor: %v", err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reader did not stop")
+		}
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		var failures []*disruptor.ConsumerError
+		collected := &collectingConsumer{}
+		consumer := disruptor.ConsumerFunc[uint64](func(e *disruptor.Events[uint64]) {
+			for _, v := range e.Range() {
+				if *v == 3 {
+					panic("boom")
+				}
+			}
+			collected.Consume(e)
+		})
+		handler := disruptor.ExceptionHandlerFunc(func(err *disruptor.ConsumerError) disruptor.ExceptionAction {
+			failures = append(failures, err)
+			return disruptor.ActionSkip
+		})
+
+		d := disruptor.NewSingleProducer[uint64](
+			disruptor.WithCapacity(16),
+			disruptor.WithExceptionHandler(handler),
+			disruptor.WithConsumer[uint64]("worker", consumer),
+		)
+		// Publish before reading so the panicking event arrives in one batch.
+		for n := uint64(0); n < 8; n++ {
+			d.Publish(func(slot *uint64) { *slot = n })
+		}
+		d.Close()
+		d.Read()
+
+		if len(failures) != 1 || failures[0].Consumer != "worker" || failures[0].Err.Error() != "boom" || failures[0].Attempt != 1 {
+			t.Fatalf("unexpected failures %v", failures)
+		}
+		if len(collected.Values()) != 0 {
+			t.Fatalf("expected the failed batch to be skipped, got %v", collected.Values())
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		var calls atomic.Int64
+		collected := &collectingConsumer{}
+		consumer := disruptor.ConsumerFunc[uint64](func(e *disruptor.Events[uint64]) {
+			if calls.Add(1) <= 2 {
+				panic(errors.New("transient"))
+			}
+			collected.Consume(e)
+		})
+		run(t, disruptor.RetryThen(3, disruptor.Halt()), consumer, 4)
+
+		if got := len(collected.Values()); got != 4 {
+			t.Fatalf("expected 4 values after retries, got %d", got)
+		}
+	})
+
+	t.Run("halt", func(t *testing.T) {
+		var consumed atomic.Int64
+		consumer := disruptor.ConsumerFunc[uint64](func(e *disruptor.Events[uint64]) {
+			consumed.Add(1)
+			panic("fatal")
+		})
+		run(t, disruptor.Halt(), consumer, 64)
+
+		if got := consumed.Load(); got != 1 {
+			t.Fatalf("expected the consumer to stop after one batch, got %d", got)
+		}
+	})
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	start := func(t *testing.T) (disruptor.SingleProducer[uint64], *disruptor.Journal[uint64], *collectingConsumer, *sync.WaitGroup) {
+		t.Helper()
+		journal, err := disruptor.OpenJournal[uint64](dir, disruptor.Uint64Codec{}, disruptor.JournalWithSegmentSize(256))
+		if err != nil {
+			t.Fatalf("open journal: %v", err)
+		}
+		logic := &collectingConsumer{}
+		d := disruptor.NewSingleProducer[uint64](
+			disruptor.WithCapacity(16),
+			disruptor.WithConsumer[uint64]("journal", journal),
+			disruptor.WithConsumer[uint64]("logic", logic).DependsOn("journal"),
+		)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Read()
+		}()
+		return d, journal, logic, &wg
+	}
+	publish := func(d disruptor.SingleProducer[uint64], from, to uint64) {
+		for n := from; n < to; n++ {
+			d.Publish(func(slot *uint64) { *slot = n * 10 })
+		}
+	}
+
+	d, _, _, wg := start(t)
+	publish(d, 0, 100)
+	d.Close()
+	wg.Wait()
+
+	// Simulate a torn write at the end of the newest segment.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.journal"))
+	if len(segments) < 2 {
+		t.Fatalf("expected the journal to roll over segments, got %d", len(segments))
+	}
+	sort.Strings(segments)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	d, journal, logic, wg := start(t)
+	replayed, err := journal.Replay(d)
+	if err != nil || replayed != 100 {
+		t.Fatalf("expected to replay 100 events, got %d: %v", replayed, err)
+	}
+	publish(d, 100, 120)
+	d.Close()
+	wg.Wait()
+
+	values := logic.Values()
+	if len(values) != 120 {
+		t.Fatalf("expected 120 values, got %d", len(values))
+	}
+	for i, v := range values {
+		if v != uint64(i)*10 {
+			t.Fatalf("expected value %d at index %d, got %d", i*10, i, v)
+		}
+	}
+
+	var count uint64
+	if err := disruptor.ReadJournal(dir, disruptor.Uint64Codec{}, func(seq uint64, v *uint64) error {
+		if seq != count || *v != count*10 {
+			return fmt.Errorf("unexpected record %d: %d", seq, *v)
+		}
+		count++
+		return nil
+	}); err != nil || count != 120 {
+		t.Fatalf("expected 120 journaled events, got %d: %v", count, err)
+	}
+}
+
+func TestJournalSyncWhileConsuming(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := disruptor.OpenJournal[uint64](dir, disruptor.Uint64Codec{},
+		disruptor.JournalWithSegmentSize(256),
+		disruptor.JournalWithSyncInterval(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	d := disruptor.NewSingleProducer[uint64](
+		disruptor.WithCapacity(16),
+		disruptor.WithConsumer[uint64]("journal", journal),
+	)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.Read()
+	}()
+
+	stop := make(chan struct{})
+	synced := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				synced <- nil
+				return
+			case <-ticker.C:
+				if err := journal.Sync(); err != nil {
+					synced <- err
+					return
+				}
+			}
+		}
+	}()
+
+	for n := range uint64(1000) {
+		d.Publish(func(slot *uint64) { *slot = n })
+	}
+	close(stop)
+	if err := <-synced; err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	d.Close()
+	wg.Wait()
+
+	var count uint64
+	if err := disruptor.ReadJournal(dir, disruptor.Uint64Codec{}, func(seq uint64, v *uint64) error {
+		if seq != count || *v != count {
+			return fmt.Errorf("unexpected record %d: %d", seq, *v)
+		}
+		count++
+		return nil
+	}); err != nil || count != 1000 {
+		t.Fatalf("expected 1000 journaled events, got %d: %v", count, err)
+	}
+}
+
+// flakyCodec fails to encode fail once.
+type flakyCodec struct {
+	disruptor.Uint64Codec
+	fail   uint64
+	failed atomic.Bool
+}
+
+func (c *flakyCodec) Encode(w io.Writer, v *uint64) error {
+	if *v == c.fail && c.failed.CompareAndSwap(false, true) {
+		return errors.New("encode failed")
+	}
+	return c.Uint64Codec.Encode(w, v)
+}
+
+func TestJournalRetriesFailedBatch(t *testing.T) {
+	dir := t.TempDir()
+	codec := &flakyCodec{fail: 37}
+	journal, err := disruptor.OpenJournal[uint64](dir, codec, disruptor.JournalWithSegmentSize(256))
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	var failures atomic.Int64
+	d := disruptor.NewSingleProducer[uint64](
+		disruptor.WithCapacity(16),
+		disruptor.WithExceptionHandler(disruptor.ExceptionHandlerFunc(func(err *disruptor.ConsumerError) disruptor.ExceptionAction {
+			failures.Add(1)
+			return disruptor.ActionRetry
+		})),
+		disruptor.WithConsumer[uint64]("journal", journal),
+	)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.Read()
+	}()
+	for n := range uint64(100) {
+		d.Publish(func(slot *uint64) { *slot = n })
+	}
+	d.Close()
+	wg.Wait()
+
+	if got := failures.Load(); got != 1 {
+		t.Fatalf("expected one failed batch, got %d", got)
+	}
+	var count uint64
+	if err := disruptor.ReadJournal(dir, disruptor.Uint64Codec{}, func(seq uint64, v *uint64) error {
+		if seq != count || *v != count {
+			return fmt.Errorf("unexpected record %d: %d", seq, *v)
+		}
+		count++
+		return nil
+	}); err != nil || count != 100 {
+		t.Fatalf("expected 100 journaled events, got %d: %v", count, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	slow := disruptor.ConsumerFunc[uint64](func(*disruptor.Events[uint64]) {
+		once.Do(func() { <-release })
+	})
+	fast := &collectingConsumer{}
+
+	d := disruptor.NewSingleProducer[uint64](
+		disruptor.WithCapacity(16),
+		disruptor.WithConsumer[uint64]("fast", fast),
+		disruptor.WithConsumer[uint64]("slow", slow),
+	)
+
+	if s := d.Stats(); s.Producer != -1 || len(s.Consumers) != 2 || s.MaxLag() != 0 {
+		t.Fatalf("unexpected initial stats %+v", s)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.Read()
+	}()
+
+	d.Publish(func(slot *uint64) { *slot = 0 })
+	for len(fast.Values()) != 1 {
+		runtime.Gosched()
+	}
+	// Counters are published once the consumer goes back to waiting.
+	deadline := time.Now().Add(time.Second)
+	for d.Stats().Consumers[0].Events != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the fast consumer to publish its first batch, got %+v", d.Stats().Consumers[0])
+		}
+		runtime.Gosched()
+	}
+	for n := uint64(1); n < 8; n++ {
+		d.Publish(func(slot *uint64) { *slot = n })
+	}
+
+	reports := make(chan disruptor.Stats, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go disruptor.ReportStats(ctx, d, time.Millisecond, func(s disruptor.Stats) {
+		select {
+		case reports <- s:
+		default:
+		}
+	})
+	s := <-reports
+	cancel()
+
+	if s.Producer != 7 || s.Capacity != 16 {
+		t.Fatalf("unexpected producer stats %+v", s)
+	}
+	if slowStats := s.Consumers[1]; slowStats.Name != "slow" || slowStats.Sequence != -1 || slowStats.Lag != 8 {
+		t.Fatalf("unexpected slow consumer stats %+v", slowStats)
+	}
+	if s.MaxLag() != 8 {
+		t.Fatalf("expected max lag 8, got %d", s.MaxLag())
+	}
+
+	close(release)
+	if err := d.Close(); err != nil {
+		t.Fatalf("close disruptor: %v", err)
+	}
+	wg.Wait()
+
+	for _, c := range d.Stats().Consumers {
+		if c.Lag != 0 || c.Events != 8 || c.Sequence != 7 {
+			t.Fatalf("unexpected final stats %+v", c)
+		}
+		if last := c.BatchSizes[len(c.BatchSizes)-1]; last.Count != c.Batches {
+			t.Fatalf("expected every batch in the histogram, got %d of %d", last.Count, c.Batches)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package disruptor
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"runtime"
@@ -23,6 +24,10 @@ var (
 	errMissingConsumers        = errors.New("no consumers have been provided")
 	errMissingConsumersInGroup = errors.New("the consumer group does not have any consumers")
 	errEmptyConsumer           = errors.New("an empty consumer was specified in the consumer group")
+	errMissingConsumerName     = errors.New("a named consumer must have a name")
+	errDuplicateConsumerName   = errors.New("the consumer name is already registered")
+	errUnknownDependency       = errors.New("the consumer depends on an unknown consumer")
+	errDependencyCycle         = errors.New("the consumer dependencies form a cycle")
 )
 
 func validateConfig[T any](cfg *config[T]) error {
@@ -38,7 +43,7 @@ func validateConfig[T any](cfg *config[T]) error {
 	if cfg.capacity > uint64(math.MaxInt64) {
 		return errCapacityTooLarge
 	}
-	if len(cfg.consumerGroups) == 0 {
+	if len(cfg.consumerGroups) == 0 && len(cfg.consumers) == 0 {
 		return errMissingConsumers
 	}
 	for _, group := range cfg.consumerGroups {
@@ -51,6 +56,55 @@ func validateConfig[T any](cfg *config[T]) error {
 			}
 		}
 	}
+	return validateConsumerGraph(cfg.consumers)
+}
+
+func validateConsumerGraph[T any](consumers []consumerOption[T]) error {
+	dependsOn := make(map[string][]string, len(consumers))
+	for _, c := range consumers {
+		if c.name == "" {
+			return errMissingConsumerName
+		}
+		if c.consumer == nil {
+			return fmt.Errorf("%w: %q", errEmptyConsumer, c.name)
+		}
+		if _, ok := dependsOn[c.name]; ok {
+			return fmt.Errorf("%w: %q", errDuplicateConsumerName, c.name)
+		}
+		dependsOn[c.name] = c.dependsOn
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(consumers))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %q", errDependencyCycle, name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range dependsOn[name] {
+			if _, ok := dependsOn[dep]; !ok {
+				return fmt.Errorf("%w: %q depends on %q", errUnknownDependency, name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, c := range consumers {
+		if err := visit(c.name); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -150,18 +204,30 @@ type noopReader struct{}
 func (noopReader) Read()        {}
 func (noopReader) Close() error { return nil }
 
+// readerShared holds the settings and state every reader of a disruptor
+// shares.
+type readerShared struct {
+	waiter  WaitStrategy
+	handler ExceptionHandler
+	halted  atomic.Bool
+}
+
 type defaultReader[T any] struct {
 	state    int64
+	name     string
 	current  *cursor
 	written  *cursor
 	upstream barrier
 	waiter   WaitStrategy
+	handler  ExceptionHandler
+	halted   *atomic.Bool
 	consumer Consumer[T]
 	events   Events[T]
 	fast     SliceConsumer[T]
+	metrics  readerMetrics
 }
 
-func newDefaultReader[T any](current, written *cursor, upstream barrier, waiter WaitStrategy, consumer Consumer[T], ring []T, mask uint64) reader {
+func newDefaultReader[T any](name string, shared *readerShared, current, written *cursor, upstream barrier, consumer Consumer[T], ring []T, mask uint64) reader {
 	var fast SliceConsumer[T]
 	if sc, ok := any(consumer).(SliceConsumer[T]); ok {
 		fast = sc
@@ -169,10 +235,13 @@ func newDefaultReader[T any](current, written *cursor, upstream barrier, waiter
 
 	return &defaultReader[T]{
 		state:    stateRunning,
+		name:     name,
 		current:  current,
 		written:  written,
 		upstream: upstream,
-		waiter:   waiter,
+		waiter:   shared.waiter,
+		handler:  shared.handler,
+		halted:   &shared.halted,
 		consumer: consumer,
 		events: Events[T]{
 			ring: ring,
@@ -184,40 +253,52 @@ func newDefaultReader[T any](current, written *cursor, upstream barrier, waiter
 
 func (r *defaultReader[T]) Read() {
 	var gateCount, idleCount, lower, upper int64
+	var metrics localMetrics
 	current := r.current.Load()
 
-	for {
+	for !r.halted.Load() {
 		lower = current + 1
 		upper = r.upstream.Load()
 
 		if lower <= upper {
-			if r.fast != nil {
-				r.fast.ConsumeSlice(uint64(lower), uint64(upper), r.events.ring, r.events.mask)
-			} else {
-				r.events.lower = uint64(lower)
-				r.events.upper = uint64(upper)
-				r.consumer.Consume(&r.events)
+			if !r.consume(lower, upper) {
+				break
 			}
+			metrics.observeBatch(&r.metrics, uint64(upper-lower+1))
 			r.current.Store(upper)
 			current = upper
 		} else if upper = r.written.Load(); lower <= upper {
 			gateCount++
 			idleCount = 0
+			metrics.observeWait(&r.metrics, &metrics.gateWaits)
 			r.waiter.Gate(uint64(gateCount))
 		} else if atomic.LoadInt64(&r.state) == stateRunning {
 			idleCount++
 			gateCount = 0
+			metrics.observeWait(&r.metrics, &metrics.idleWaits)
 			r.waiter.Idle(uint64(idleCount))
 		} else {
 			break
 		}
 	}
 
+	metrics.publish(&r.metrics)
+
 	if closer, ok := r.consumer.(io.Closer); ok {
 		_ = closer.Close()
 	}
 }
 
+func (r *defaultReader[T]) dispatch(lower, upper int64) {
+	if r.fast != nil {
+		r.fast.ConsumeSlice(uint64(lower), uint64(upper), r.events.ring, r.events.mask)
+	} else {
+		r.events.lower = uint64(lower)
+		r.events.upper = uint64(upper)
+		r.consumer.Consume(&r.events)
+	}
+}
+
 func (r *defaultReader[T]) Close() error {
 	atomic.StoreInt64(&r.state, stateClosed)
 	return nil
@@ -0,0 +1,134 @@
+package disruptor
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// ConsumerError describes a batch whose consumer panicked.
+type ConsumerError struct {
+	// Consumer is the name given to WithConsumer, or "group G consumer C" for
+	// consumers registered with WithConsumerGroup, counting from zero.
+	Consumer string
+	Lower    uint64
+	Upper    uint64
+	// Attempt counts the times the batch was tried, starting at one.
+	Attempt int
+	// Err is the panic value, wrapped in an error when it is not one.
+	Err   error
+	Stack []byte
+}
+
+func (e *ConsumerError) Error() string {
+	return fmt.Sprintf("disruptor: consumer %s panicked on sequences %d-%d (attempt %d): %v", e.Consumer, e.Lower, e.Upper, e.Attempt, e.Err)
+}
+
+func (e *ConsumerError) Unwrap() error { return e.Err }
+
+// ExceptionAction tells a reader what to do after its consumer panicked.
+type ExceptionAction int
+
+const (
+	// ActionSkip moves past the batch as if it had been consumed.
+	ActionSkip ExceptionAction = iota
+	// ActionRetry hands the same batch to the consumer again.
+	ActionRetry
+	// ActionHalt stops every reader without draining, so Read returns.
+	// Writers blocked on a full ring stay blocked, use ReserveCtx to give up.
+	ActionHalt
+)
+
+// ExceptionHandler decides how a reader recovers from a panicking consumer.
+// It is called from the reader goroutine, so it also serves as the error
+// callback.
+type ExceptionHandler interface {
+	HandleException(err *ConsumerError) ExceptionAction
+}
+
+// ExceptionHandlerFunc adapts a plain function so it can be used as an
+// ExceptionHandler.
+type ExceptionHandlerFunc func(err *ConsumerError) ExceptionAction
+
+// HandleException invokes the underlying function.
+func (f ExceptionHandlerFunc) HandleException(err *ConsumerError) ExceptionAction { return f(err) }
+
+// LogAndSkip logs the panic at error level and skips the batch.
+func LogAndSkip(logger *slog.Logger) ExceptionHandler {
+	return ExceptionHandlerFunc(func(err *ConsumerError) ExceptionAction {
+		logger.Error("disruptor consumer panicked, skipping batch",
+			slog.String("consumer", err.Consumer),
+			slog.Uint64("lower", err.Lower),
+			slog.Uint64("upper", err.Upper),
+			slog.Int("attempt", err.Attempt),
+			slog.Any("error", err.Err),
+			slog.String("stack", string(err.Stack)),
+		)
+		return ActionSkip
+	})
+}
+
+// Halt stops the disruptor on the first panic.
+func Halt() ExceptionHandler {
+	return ExceptionHandlerFunc(func(*ConsumerError) ExceptionAction {
+		return ActionHalt
+	})
+}
+
+// RetryThen retries a panicking batch until it has been tried attempts times
+// and then defers to fallback.
+func RetryThen(attempts int, fallback ExceptionHandler) ExceptionHandler {
+	return ExceptionHandlerFunc(func(err *ConsumerError) ExceptionAction {
+		if err.Attempt < attempts {
+			return ActionRetry
+		}
+		return fallback.HandleException(err)
+	})
+}
+
+// consume hands lower-upper to the consumer, recovering panics through the
+// exception handler. It reports false when the reader must halt.
+func (r *defaultReader[T]) consume(lower, upper int64) bool {
+	if r.handler == nil {
+		r.dispatch(lower, upper)
+		return true
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.tryDispatch(lower, upper)
+		if err == nil {
+			return true
+		}
+		err.Attempt = attempt
+
+		switch r.handler.HandleException(err) {
+		case ActionSkip:
+			return true
+		case ActionRetry:
+			continue
+		default:
+			r.halted.Store(true)
+			return false
+		}
+	}
+}
+
+func (r *defaultReader[T]) tryDispatch(lower, upper int64) (cerr *ConsumerError) {
+	defer func() {
+		if v := recover(); v != nil {
+			err, ok := v.(error)
+			if !ok {
+				err = fmt.Errorf("%v", v)
+			}
+			cerr = &ConsumerError{
+				Consumer: r.name,
+				Lower:    uint64(lower),
+				Upper:    uint64(upper),
+				Err:      err,
+				Stack:    debug.Stack(),
+			}
+		}
+	}()
+	r.dispatch(lower, upper)
+	return nil
+}
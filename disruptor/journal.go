@@ -0,0 +1,603 @@
+package disruptor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/delaneyj/toolbelt"
+)
+
+// Codec encodes events to and decodes them from the journal.
+type Codec[T any] interface {
+	Encode(w io.Writer, v *T) error
+	Decode(r io.Reader, v *T) error
+}
+
+// Uint64Codec journals uint64 events.
+type Uint64Codec struct{}
+
+func (Uint64Codec) Encode(w io.Writer, v *uint64) error { return toolbelt.WriteUint64(w, *v) }
+
+func (Uint64Codec) Decode(r io.Reader, v *uint64) (err error) {
+	*v, err = toolbelt.ReadUint64(r)
+	return err
+}
+
+// StringCodec journals string events.
+type StringCodec struct{}
+
+func (StringCodec) Encode(w io.Writer, v *string) error { return toolbelt.WriteString(w, *v) }
+
+func (StringCodec) Decode(r io.Reader, v *string) (err error) {
+	*v, err = toolbelt.ReadString(r)
+	return err
+}
+
+// JournalSync controls when the journal is flushed to stable storage.
+type JournalSync int
+
+const (
+	// JournalSyncEveryBatch fsyncs after every batch, so a consumer gated on
+	// the journal only sees durable events.
+	JournalSyncEveryBatch JournalSync = iota
+	// JournalSyncInterval fsyncs at most once per sync interval: after a
+	// batch once the interval has passed since the last fsync, otherwise
+	// from a timer when the interval is up, and on Close.
+	JournalSyncInterval
+	// JournalSyncNever leaves flushing to the operating system, except on
+	// Close.
+	JournalSyncNever
+)
+
+type journalOptions struct {
+	sync         JournalSync
+	syncInterval time.Duration
+	segmentSize  int64
+}
+
+type JournalOption func(*journalOptions)
+
+// JournalWithSync sets the fsync policy. It defaults to JournalSyncEveryBatch.
+func JournalWithSync(sync JournalSync) JournalOption {
+	return func(o *journalOptions) {
+		o.sync = sync
+	}
+}
+
+// JournalWithSyncInterval fsyncs at most once per interval, see
+// JournalSyncInterval.
+func JournalWithSyncInterval(interval time.Duration) JournalOption {
+	return func(o *journalOptions) {
+		o.sync = JournalSyncInterval
+		o.syncInterval = interval
+	}
+}
+
+// JournalWithSegmentSize sets the size in bytes after which the journal moves
+// on to a new segment file. It defaults to 64 MiB.
+func JournalWithSegmentSize(size int64) JournalOption {
+	return func(o *journalOptions) {
+		o.segmentSize = size
+	}
+}
+
+const (
+	journalMagic          = "TBJRNL01"
+	journalSegmentExt     = ".journal"
+	journalMaxRecordSize  = 1 << 30
+	defaultJournalSegment = 64 << 20
+)
+
+var (
+	// ErrJournalCorrupt is returned when a journal segment other than the
+	// last one has a damaged record. Damage at the end of the last segment is
+	// treated as a torn write and truncated when the journal is opened.
+	ErrJournalCorrupt = errors.New("the journal is corrupt")
+	// ErrJournalFailed is raised by every Consume after a failed write could
+	// not be undone, since appending more would leave a damaged record behind.
+	ErrJournalFailed = errors.New("the journal failed and was closed")
+
+	journalTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Journal is a consumer that appends every event to a segmented log of
+// checksummed records in a directory. Register it with WithConsumer and make
+// the consumers that need durable events depend on it. Write failures panic
+// with the error, so WithExceptionHandler decides whether to retry or halt.
+// The records of the failed batch are truncated away first, so a retried
+// batch is written again in full; if that fails too the journal is closed
+// and panics with ErrJournalFailed from then on.
+//
+// Each record holds the event's sequence, so after a restart the events the
+// journal already holds are skipped rather than written again. Use Replay to
+// publish them into the fresh disruptor.
+type Journal[T any] struct {
+	dir     string
+	codec   Codec[T]
+	options journalOptions
+
+	// mu guards the fields below, so Sync can run alongside Consume.
+	mu        sync.Mutex
+	syncTimer *time.Timer
+	// syncErr is a failed timer-driven fsync, raised by the next Consume.
+	syncErr error
+	// failed is set once the journal closed after a failure, see rewind.
+	failed   error
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	lastSync time.Time
+	payload  bytes.Buffer
+	record   bytes.Buffer
+
+	// last is the highest sequence in the journal, valid when hasLast is set.
+	last    uint64
+	hasLast bool
+	// flushed is the size of the current segment as of the last flush, and
+	// flushedLast its last sequence, where rewind goes back to.
+	flushed        int64
+	flushedLast    uint64
+	flushedHasLast bool
+	// replayed is last as of opening, the end of what Replay publishes.
+	replayed    uint64
+	hasReplayed bool
+}
+
+// OpenJournal opens or creates the journal in dir, truncating a torn record
+// at the end of the newest segment.
+func OpenJournal[T any](dir string, codec Codec[T], opts ...JournalOption) (*Journal[T], error) {
+	options := journalOptions{
+		segmentSize:  defaultJournalSegment,
+		syncInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create journal directory: %w", err)
+	}
+	segments, err := journalSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal[T]{dir: dir, codec: codec, options: options, lastSync: time.Now()}
+	for i := len(segments) - 1; i >= 0 && !j.hasLast; i-- {
+		var valid int64
+		valid, err = readJournalSegment(segments[i], func(seq uint64, _ []byte) error {
+			j.last, j.hasLast = seq, true
+			return nil
+		})
+		if i == len(segments)-1 {
+			// A failed or partial write can only leave damage at the end of
+			// the newest segment, everything after the last good record goes.
+			if err != nil && !errors.Is(err, ErrJournalCorrupt) {
+				return nil, err
+			}
+			if err := j.openSegment(segments[i], valid); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	j.replayed, j.hasReplayed = j.last, j.hasLast
+	return j, nil
+}
+
+// Consume appends the batch, skipping the events already in the journal.
+func (j *Journal[T]) Consume(events *Events[T]) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.failed != nil {
+		panic(j.failed)
+	}
+	if err := j.syncErr; err != nil {
+		j.syncErr = nil
+		panic(err)
+	}
+
+	wrote := false
+	for seq, v := range events.Range() {
+		if j.hasLast && seq <= j.last {
+			continue
+		}
+		if err := j.append(seq, v); err != nil {
+			j.fail(fmt.Errorf("could not append to journal: %w", err))
+		}
+		wrote = true
+	}
+	if !wrote {
+		return
+	}
+
+	if err := j.flush(); err != nil {
+		j.fail(err)
+	}
+	switch j.options.sync {
+	case JournalSyncEveryBatch:
+		if err := j.sync(); err != nil {
+			j.fail(err)
+		}
+	case JournalSyncInterval:
+		if wait := j.options.syncInterval - time.Since(j.lastSync); wait <= 0 {
+			if err := j.sync(); err != nil {
+				j.fail(err)
+			}
+		} else if j.syncTimer == nil {
+			j.syncTimer = time.AfterFunc(wait, j.syncLater)
+		}
+	}
+}
+
+// fail undoes the unflushed part of the batch and panics with err, for the
+// exception handler.
+func (j *Journal[T]) fail(err error) {
+	j.rewind(err)
+	panic(err)
+}
+
+// rewind drops everything written since the last flush, so the segment ends
+// with a whole record and the buffered writer, whose errors are sticky, can
+// be used again. If the segment can not be restored the journal is closed.
+func (j *Journal[T]) rewind(cause error) {
+	if j.file == nil {
+		return
+	}
+	err := j.file.Truncate(j.flushed)
+	if err == nil {
+		_, err = j.file.Seek(j.flushed, io.SeekStart)
+	}
+	if err != nil {
+		j.file.Close()
+		j.file, j.writer = nil, nil
+		j.failed = fmt.Errorf("%w: %w, then could not truncate the segment: %w", ErrJournalFailed, cause, err)
+		return
+	}
+	j.writer.Reset(j.file)
+	j.size = j.flushed
+	j.last, j.hasLast = j.flushedLast, j.flushedHasLast
+}
+
+// flush writes the buffered records to the segment.
+func (j *Journal[T]) flush() error {
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("could not flush journal: %w", err)
+	}
+	j.flushed = j.size
+	j.flushedLast, j.flushedHasLast = j.last, j.hasLast
+	return nil
+}
+
+// Sync flushes the journal to stable storage. It is safe to call while the
+// disruptor runs.
+func (j *Journal[T]) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sync()
+}
+
+// syncLater runs from the sync timer, so a quiet period after a batch does
+// not leave it unsynced for longer than the sync interval.
+func (j *Journal[T]) syncLater() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.syncTimer = nil
+	if err := j.sync(); err != nil {
+		j.syncErr = err
+	}
+}
+
+func (j *Journal[T]) sync() error {
+	if j.syncTimer != nil {
+		j.syncTimer.Stop()
+		j.syncTimer = nil
+	}
+	if j.file == nil {
+		return nil
+	}
+	if err := j.flush(); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync journal: %w", err)
+	}
+	j.lastSync = time.Now()
+	return nil
+}
+
+// Close syncs and closes the journal. The disruptor calls it when Read
+// returns.
+func (j *Journal[T]) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.syncErr
+	j.syncErr = nil
+	if err == nil {
+		err = j.failed
+	}
+	if j.file == nil {
+		return err
+	}
+	if syncErr := j.sync(); syncErr != nil && err == nil {
+		err = syncErr
+	}
+	if closeErr := j.file.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("could not close journal: %w", closeErr)
+	}
+	j.file, j.writer = nil, nil
+	return err
+}
+
+// Replay publishes the events that were in the journal when it was opened
+// into p and returns how many it published. It must run before anything
+// else is published so the events get their original sequences, and p must
+// be reading so the ring does not fill up.
+func (j *Journal[T]) Replay(p Producer[T]) (uint64, error) {
+	if !j.hasReplayed {
+		return 0, nil
+	}
+
+	var count uint64
+	err := ReadJournal(j.dir, j.codec, func(seq uint64, v *T) error {
+		if seq > j.replayed {
+			return errStopJournal
+		}
+		published := p.Publish(func(slot *T) {
+			*slot = *v
+		})
+		if published != seq {
+			return fmt.Errorf("journal sequence %d was replayed as %d", seq, published)
+		}
+		count++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopJournal) {
+		return count, err
+	}
+	return count, nil
+}
+
+var errStopJournal = errors.New("stop reading journal")
+
+// ReadJournal decodes every record in the journal in dir in sequence order
+// and calls fn with it. v is reused between calls. A torn record at the end
+// of the newest segment ends the journal.
+func ReadJournal[T any](dir string, codec Codec[T], fn func(seq uint64, v *T) error) error {
+	segments, err := journalSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	var v T
+	for i, segment := range segments {
+		_, err := readJournalSegment(segment, func(seq uint64, payload []byte) error {
+			if err := codec.Decode(bytes.NewReader(payload), &v); err != nil {
+				return fmt.Errorf("could not decode journal record %d: %w", seq, err)
+			}
+			return fn(seq, &v)
+		})
+		if errors.Is(err, ErrJournalCorrupt) && i == len(segments)-1 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Journal[T]) append(seq uint64, v *T) error {
+	if j.file == nil || j.size >= j.options.segmentSize {
+		if err := j.roll(seq); err != nil {
+			return err
+		}
+	}
+
+	j.payload.Reset()
+	if err := j.codec.Encode(&j.payload, v); err != nil {
+		return fmt.Errorf("could not encode event %d: %w", seq, err)
+	}
+	if j.payload.Len() > journalMaxRecordSize {
+		return fmt.Errorf("event %d encodes to %d bytes, more than the journal allows", seq, j.payload.Len())
+	}
+
+	j.record.Reset()
+	toolbelt.WriteUint64(&j.record, seq)
+	toolbelt.WriteUint32(&j.record, uint32(j.payload.Len()))
+	j.record.Write(j.payload.Bytes())
+	toolbelt.WriteUint32(&j.record, crc32.Checksum(j.record.Bytes(), journalTable))
+
+	if _, err := j.writer.Write(j.record.Bytes()); err != nil {
+		return err
+	}
+	j.size += int64(j.record.Len())
+	j.last, j.hasLast = seq, true
+	return nil
+}
+
+// roll closes the current segment and starts a new one named after the
+// first sequence it holds.
+func (j *Journal[T]) roll(first uint64) error {
+	if j.file != nil {
+		if err := j.flush(); err != nil {
+			return err
+		}
+		if j.options.sync != JournalSyncNever {
+			if err := j.sync(); err != nil {
+				return err
+			}
+		}
+		if err := j.file.Close(); err != nil {
+			return fmt.Errorf("could not close journal segment: %w", err)
+		}
+		j.file, j.writer = nil, nil
+	}
+
+	name := filepath.Join(j.dir, fmt.Sprintf("%020d%s", first, journalSegmentExt))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create journal segment: %w", err)
+	}
+	if _, err := io.WriteString(f, journalMagic); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write journal segment: %w", err)
+	}
+	if j.options.sync != JournalSyncNever {
+		// The segment must survive a crash along with the records synced
+		// into it, which takes syncing the directory entry as well.
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("could not sync journal segment: %w", err)
+		}
+		if err := syncDir(j.dir); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	j.file, j.writer, j.size = f, bufio.NewWriter(f), int64(len(journalMagic))
+	j.flushed = j.size
+	j.flushedLast, j.flushedHasLast = j.last, j.hasLast
+	return nil
+}
+
+// openSegment reopens the newest segment for appending after its last valid
+// record.
+func (j *Journal[T]) openSegment(name string, valid int64) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open journal segment: %w", err)
+	}
+	if valid < int64(len(journalMagic)) {
+		valid = 0
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("could not truncate journal segment: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("could not seek journal segment: %w", err)
+	}
+	if valid == 0 {
+		if _, err := io.WriteString(f, journalMagic); err != nil {
+			f.Close()
+			return fmt.Errorf("could not write journal segment: %w", err)
+		}
+		valid = int64(len(journalMagic))
+	}
+	// Sync the truncation, so the dropped tail can not come back after a
+	// crash and be followed by new records.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not sync journal segment: %w", err)
+	}
+	j.file, j.writer, j.size = f, bufio.NewWriter(f), valid
+	j.flushed = valid
+	j.flushedLast, j.flushedHasLast = j.last, j.hasLast
+	return nil
+}
+
+// syncDir syncs the directory entries of dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open journal directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not sync journal directory: %w", err)
+	}
+	return nil
+}
+
+func journalSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read journal directory: %w", err)
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), journalSegmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseUint(base, 10, 64); err != nil {
+			continue
+		}
+		segments = append(segments, filepath.Join(dir, entry.Name()))
+	}
+	// Names are zero padded, so lexical order is sequence order.
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// readJournalSegment calls fn for every record in the segment and returns
+// the offset just past the last valid one. A damaged or partial record stops
+// the read with ErrJournalCorrupt.
+func readJournalSegment(name string, fn func(seq uint64, payload []byte) error) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("could not open journal segment: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(journalMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != journalMagic {
+		return 0, fmt.Errorf("%w: %s has no journal header", ErrJournalCorrupt, filepath.Base(name))
+	}
+
+	offset := int64(len(journalMagic))
+	var header [12]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, fmt.Errorf("%w: partial record header in %s", ErrJournalCorrupt, filepath.Base(name))
+		}
+
+		hr := bytes.NewReader(header[:])
+		seq, _ := toolbelt.ReadUint64(hr)
+		size, _ := toolbelt.ReadUint32(hr)
+		if size > journalMaxRecordSize {
+			return offset, fmt.Errorf("%w: record %d in %s is too large", ErrJournalCorrupt, seq, filepath.Base(name))
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, fmt.Errorf("%w: partial record %d in %s", ErrJournalCorrupt, seq, filepath.Base(name))
+		}
+		sum, err := toolbelt.ReadUint32(r)
+		if err != nil {
+			return offset, fmt.Errorf("%w: partial record %d in %s", ErrJournalCorrupt, seq, filepath.Base(name))
+		}
+		crc := crc32.Update(crc32.Checksum(header[:], journalTable), journalTable, payload)
+		if crc != sum {
+			return offset, fmt.Errorf("%w: checksum mismatch for record %d in %s", ErrJournalCorrupt, seq, filepath.Base(name))
+		}
+
+		if err := fn(seq, payload); err != nil {
+			return offset, err
+		}
+		offset += int64(len(header)) + int64(size) + 4
+	}
+}
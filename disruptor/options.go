@@ -1,13 +1,15 @@
 package disruptor
 
 type baseConfig struct {
-	capacity     uint64
-	waitStrategy WaitStrategy
+	capacity         uint64
+	waitStrategy     WaitStrategy
+	exceptionHandler ExceptionHandler
 }
 
 type config[T any] struct {
 	baseConfig
 	consumerGroups [][]Consumer[T]
+	consumers      []consumerOption[T]
 }
 
 func newConfig[T any]() *config[T] {
@@ -36,6 +38,17 @@ func WithWaitStrategy(value WaitStrategy) waitOption {
 	return waitOption{value: value}
 }
 
+type exceptionHandlerOption struct {
+	value ExceptionHandler
+}
+
+// WithExceptionHandler recovers panics raised by consumers and lets handler
+// decide whether to skip the batch, retry it or halt. Without a handler a
+// panicking consumer crashes the program.
+func WithExceptionHandler(handler ExceptionHandler) exceptionHandlerOption {
+	return exceptionHandlerOption{value: handler}
+}
+
 type consumerGroupOption[T any] struct {
 	consumers []Consumer[T]
 }
@@ -45,3 +58,23 @@ type consumerGroupOption[T any] struct {
 func WithConsumerGroup[T any](consumers ...Consumer[T]) consumerGroupOption[T] {
 	return consumerGroupOption[T]{consumers: consumers}
 }
+
+type consumerOption[T any] struct {
+	name      string
+	consumer  Consumer[T]
+	dependsOn []string
+}
+
+// WithConsumer registers a named consumer. Without dependencies it processes
+// events as soon as they are published, in parallel with every other
+// consumer. Use DependsOn to build diamond and fan-in topologies.
+func WithConsumer[T any](name string, consumer Consumer[T]) consumerOption[T] {
+	return consumerOption[T]{name: name, consumer: consumer}
+}
+
+// DependsOn makes the consumer process an event only after every named
+// consumer has processed it.
+func (o consumerOption[T]) DependsOn(names ...string) consumerOption[T] {
+	o.dependsOn = append(append([]string(nil), o.dependsOn...), names...)
+	return o
+}
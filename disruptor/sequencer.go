@@ -31,6 +31,9 @@ func (p *paddedInt64) Store(v int64) {
 // sequencer coordinates writer reservations and commits.
 type sequencer interface {
 	next(count int64, gate barrier) (lower, upper int64)
+	// tryNext is next without waiting, ok is false when the reservation would
+	// overwrite entries the gate has not released yet.
+	tryNext(count int64, gate barrier) (lower, upper int64, ok bool)
 	publish(lower, upper int64)
 }
 
@@ -51,20 +54,36 @@ func newSingleProducerSequencer(capacity int64, cursor *cursor) *singleProducerS
 	}
 }
 
+// claim waits until s can reserve count entries, yielding while the gate
+// holds the reservation back.
+func claim(s sequencer, count int64, gate barrier) (int64, int64) {
+	for spin := int64(0); ; spin++ {
+		if lower, upper, ok := s.tryNext(count, gate); ok {
+			return lower, upper
+		}
+		if spin&SpinMask == 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
 func (s *singleProducerSequencer) next(count int64, upstream barrier) (int64, int64) {
+	return claim(s, count, upstream)
+}
+
+func (s *singleProducerSequencer) tryNext(count int64, upstream barrier) (int64, int64, bool) {
 	if count <= 0 {
 		panic(ErrMinimumReservationSize)
 	}
-	next := s.previous.Add(count)
-	for spin := int64(0); next-s.capacity > s.gate.Load(); spin++ {
-		if spin&SpinMask == 0 {
-			runtime.Gosched()
-		}
+	next := s.previous.Load() + count
+	if next-s.capacity > s.gate.Load() {
 		s.gate.Store(upstream.Load())
+		if next-s.capacity > s.gate.Load() {
+			return 0, 0, false
+		}
 	}
-	upper := next
-	lower := upper - (count - 1)
-	return lower, upper
+	s.previous.Store(next)
+	return next - (count - 1), next, true
 }
 
 func (s *singleProducerSequencer) publish(lower, upper int64) {
@@ -98,6 +117,10 @@ func newMultiSequencer(capacity int64, cursor *cursor) *multiSequencer {
 }
 
 func (s *multiSequencer) next(count int64, gating barrier) (int64, int64) {
+	return claim(s, count, gating)
+}
+
+func (s *multiSequencer) tryNext(count int64, gating barrier) (int64, int64, bool) {
 	if count <= 0 || count > s.capacity {
 		panic("disruptor: reservation count out of range")
 	}
@@ -112,14 +135,12 @@ func (s *multiSequencer) next(count int64, gating barrier) (int64, int64) {
 			gatingSequence := gating.Load()
 			s.cachedValue.Store(gatingSequence)
 			if wrapPoint > gatingSequence {
-				runtime.Gosched()
-				continue
+				return 0, 0, false
 			}
 		}
 
 		if s.nextValue.CompareAndSwap(current, next) {
-			lower := next - (count - 1)
-			return lower, next
+			return next - (count - 1), next, true
 		}
 	}
 }
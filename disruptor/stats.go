@@ -0,0 +1,208 @@
+package disruptor
+
+import (
+	"context"
+	"log/slog"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time view of a disruptor's progress.
+type Stats struct {
+	Capacity uint64
+	// Producer is the highest published sequence, -1 before the first
+	// publish.
+	Producer  int64
+	Consumers []ConsumerStats
+}
+
+// MaxLag reports the lag of the consumer furthest behind the producer.
+func (s Stats) MaxLag() uint64 {
+	var lag uint64
+	for _, c := range s.Consumers {
+		lag = max(lag, c.Lag)
+	}
+	return lag
+}
+
+// ConsumerStats describes one consumer. Counters only increase. Consumers
+// publish their counters every few batches and whenever they start waiting, so
+// the counters may trail Sequence slightly while a consumer is busy.
+type ConsumerStats struct {
+	// Name is the name given to WithConsumer, or "group G consumer C" for
+	// consumers registered with WithConsumerGroup.
+	Name string
+	// Sequence is the last sequence the consumer finished, -1 before the
+	// first batch.
+	Sequence int64
+	// Lag is how many published events the consumer has not finished yet.
+	Lag     uint64
+	Batches uint64
+	Events  uint64
+	// BatchSizes counts batches by number of events. Bucket counts are
+	// cumulative, each counting the batches at or below its upper bound;
+	// larger batches only count towards Batches.
+	BatchSizes []BatchSizeBucket
+	// GateWaits counts the waits for the consumers this one depends on while
+	// the producer was ahead, IdleWaits the waits for the producer.
+	GateWaits uint64
+	IdleWaits uint64
+}
+
+type BatchSizeBucket struct {
+	UpperBound uint64
+	Count      uint64
+}
+
+// batchSizeBuckets bound batch sizes at successive powers of two, from 1 up
+// to 1<<(batchSizeBuckets-1).
+const batchSizeBuckets = 17
+
+// metricsPublishInterval is how many batches or waits a reader counts locally
+// before publishing them to its readerMetrics.
+const metricsPublishInterval = 64
+
+// readerMetrics holds the counters a reader last published for Stats.
+type readerMetrics struct {
+	batches    atomic.Uint64
+	events     atomic.Uint64
+	gateWaits  atomic.Uint64
+	idleWaits  atomic.Uint64
+	batchSizes [batchSizeBuckets]atomic.Uint64
+}
+
+// localMetrics counts on the reader goroutine, keeping atomic writes off the
+// hot path.
+type localMetrics struct {
+	batches    uint64
+	events     uint64
+	gateWaits  uint64
+	idleWaits  uint64
+	batchSizes [batchSizeBuckets]uint64
+	pending    uint64
+	consumed   bool
+}
+
+func (l *localMetrics) observeBatch(m *readerMetrics, size uint64) {
+	l.batches++
+	l.events += size
+	if i := bits.Len64(size - 1); i < batchSizeBuckets {
+		l.batchSizes[i]++
+	}
+	l.consumed = true
+	if l.pending++; l.pending >= metricsPublishInterval {
+		l.publish(m)
+	}
+}
+
+// observeWait counts a wait, publishing first when batches are pending so the
+// counters are current while the reader waits.
+func (l *localMetrics) observeWait(m *readerMetrics, waits *uint64) {
+	*waits++
+	if l.pending++; l.consumed || l.pending >= metricsPublishInterval {
+		l.publish(m)
+	}
+}
+
+func (l *localMetrics) publish(m *readerMetrics) {
+	m.batches.Store(l.batches)
+	m.events.Store(l.events)
+	m.gateWaits.Store(l.gateWaits)
+	m.idleWaits.Store(l.idleWaits)
+	for i, n := range l.batchSizes {
+		m.batchSizes[i].Store(n)
+	}
+	l.pending, l.consumed = 0, false
+}
+
+type statsReader interface {
+	stats(producer int64) ConsumerStats
+}
+
+func (r *defaultReader[T]) stats(producer int64) ConsumerStats {
+	s := ConsumerStats{
+		Name:       r.name,
+		Sequence:   r.current.Load(),
+		Batches:    r.metrics.batches.Load(),
+		Events:     r.metrics.events.Load(),
+		GateWaits:  r.metrics.gateWaits.Load(),
+		IdleWaits:  r.metrics.idleWaits.Load(),
+		BatchSizes: make([]BatchSizeBucket, batchSizeBuckets),
+	}
+	if producer > s.Sequence {
+		s.Lag = uint64(producer - s.Sequence)
+	}
+	var cumulative uint64
+	for i := range s.BatchSizes {
+		cumulative += r.metrics.batchSizes[i].Load()
+		s.BatchSizes[i] = BatchSizeBucket{UpperBound: 1 << i, Count: cumulative}
+	}
+	return s
+}
+
+// Stats reports the producer cursor and the progress of every consumer. It is
+// safe to call while the disruptor runs; the values are read independently so
+// they may be slightly apart.
+func (d *baseDisruptor[T]) Stats() Stats {
+	producer := d.cursor.Load()
+	s := Stats{
+		Capacity:  uint64(len(d.ring)),
+		Producer:  producer,
+		Consumers: make([]ConsumerStats, 0, len(d.readers)),
+	}
+	for _, r := range d.readers {
+		if sr, ok := r.(statsReader); ok {
+			s.Consumers = append(s.Consumers, sr.stats(producer))
+		}
+	}
+	return s
+}
+
+// StatsSource is implemented by anything that reports disruptor stats.
+type StatsSource interface {
+	Stats() Stats
+}
+
+// ReportStats calls fn with the stats of source every interval until ctx is
+// done.
+func ReportStats(ctx context.Context, source StatsSource, interval time.Duration, fn func(Stats)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(source.Stats())
+		}
+	}
+}
+
+// LogStats returns a ReportStats callback that logs each consumer at debug
+// level, and at warn level once its lag reaches lagThreshold. A zero
+// threshold never warns.
+func LogStats(logger *slog.Logger, lagThreshold uint64) func(Stats) {
+	return func(s Stats) {
+		for _, c := range s.Consumers {
+			level := slog.LevelDebug
+			msg := "disruptor consumer stats"
+			if lagThreshold > 0 && c.Lag >= lagThreshold {
+				level = slog.LevelWarn
+				msg = "disruptor consumer is falling behind"
+			}
+			logger.Log(context.Background(), level, msg,
+				slog.String("consumer", c.Name),
+				slog.Int64("producer", s.Producer),
+				slog.Int64("sequence", c.Sequence),
+				slog.Uint64("lag", c.Lag),
+				slog.Uint64("capacity", s.Capacity),
+				slog.Uint64("batches", c.Batches),
+				slog.Uint64("events", c.Events),
+				slog.Uint64("gate_waits", c.GateWaits),
+				slog.Uint64("idle_waits", c.IdleWaits),
+			)
+		}
+	}
+}
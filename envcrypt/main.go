@@ -12,9 +12,9 @@ import (
 	"path/filepath"
 
 	"github.com/alecthomas/kong"
+	"github.com/delaneyj/toolbelt"
 	"github.com/dustin/go-humanize"
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
@@ -44,7 +44,7 @@ func run(ctx context.Context) error {
 
 func parse(password, salt, extension string) (aead cipher.AEAD, filepaths []string, err error) {
 
-	key := argon2.Key([]byte(password), []byte(salt), 3, 64*1024, 4, 32)
+	key := toolbelt.DeriveKey([]byte(password), []byte(salt))
 	aead, err = chacha20poly1305.New(key)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create chacha20poly1305: %w", err)